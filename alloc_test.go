@@ -0,0 +1,43 @@
+//go:build syscall
+// +build syscall
+
+package usnet
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConnAllocs: the steady-state Read and Write allocate nothing,
+// the irqs are pooled and the deadline job is reused.
+func TestConnAllocs(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+	defer conn.Close()
+	go io.Copy(client, client) // echo
+
+	input, output := []byte("data_xxxx"), make([]byte, 64)
+	echo := func() {
+		if _, err := conn.Write(input); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, output[:len(input)]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	echo() // warm up the pools
+	assert.Zero(t, testing.AllocsPerRun(100, echo))
+
+	// an idle timeout is refreshed before every io.
+	deadline := time.Now().Add(time.Hour)
+	assert.Zero(t, testing.AllocsPerRun(100, func() {
+		deadline = deadline.Add(time.Millisecond)
+		conn.SetDeadline(deadline)
+		echo()
+	}))
+}
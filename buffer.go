@@ -7,17 +7,26 @@ import (
 
 type buffer struct {
 	entity   *uscall.CSlice
+	view     *uscall.CSlice // the unread data area returned by CData
 	shadow   []byte
 	pos, len int
 }
 
 func newBuffer(cap uint32) (b *buffer) {
-	b = new(buffer)
+	b, _ = allocBuffer(cap, uscall.AllocMalloc, -1)
+	return
+}
+
+// allocBuffer: alloc the buffer from a on node, huge reports whether it is backed by hugepages.
+func allocBuffer(cap uint32, a uscall.Allocator, node int) (b *buffer, huge bool) {
+	b = emptyBuffer()
 	//  alloc a C.struct_slice, share the memory with buffer.
-	b.entity = uscall.AllocCSlice(cap, cap)
+	b.entity, huge = uscall.AllocCSliceOn(cap, cap, a, node)
+	memory.charge(int(cap))
 	runtime.SetFinalizer(b.entity, func(cs *uscall.CSlice) {
 		if cs != nil {
 			uscall.FreeCSlice(cs)
+			memory.uncharge(int(cap))
 		}
 	})
 
@@ -25,6 +34,27 @@ func newBuffer(cap uint32) (b *buffer) {
 	return
 }
 
+// emptyBuffer: the buffer without memory, it is taken by swap.
+func emptyBuffer() *buffer {
+	return &buffer{view: new(uscall.CSlice)} // passed to cgo, must not live in buffer with the Go pointers
+}
+
+// swap: exchange the memory and data with o, the view is kept.
+func (b *buffer) swap(o *buffer) {
+	b.entity, o.entity = o.entity, b.entity
+	b.shadow, o.shadow = o.shadow, b.shadow
+	b.pos, o.pos = o.pos, b.pos
+	b.len, o.len = o.len, b.len
+}
+
+// free: free the C memory at once, the buffer must not be used after.
+func (b *buffer) free() {
+	runtime.SetFinalizer(b.entity, nil)
+	uscall.FreeCSlice(b.entity)
+	memory.uncharge(len(b.shadow))
+	b.entity, b.shadow, b.pos, b.len = nil, nil, 0, 0
+}
+
 func (b *buffer) setLen(len int) *buffer {
 	b.len = len
 	return b
@@ -50,8 +80,32 @@ func (b *buffer) Data() []byte {
 	return b.shadow[b.pos : b.pos+b.len]
 }
 
+// CData: the view is reused, it is valid until the next call.
 func (b *buffer) CData() *uscall.CSlice {
-	return uscall.Bytes2CSlice(b.Data())
+	return uscall.Bytes2CSliceTo(b.Data(), b.view)
+}
+
+// CSpace: the free area after the unread data, the view is shared with CData.
+func (b *buffer) CSpace() *uscall.CSlice {
+	return uscall.Bytes2CSliceTo(b.shadow[b.pos+b.len:], b.view)
+}
+
+// Space: the bytes of the free area after the unread data.
+func (b *buffer) Space() int {
+	return len(b.shadow) - b.pos - b.len
+}
+
+// discard: skip n bytes of the unread data at most, return the skipped size.
+func (b *buffer) discard(n int) int {
+	if n >= b.len {
+		n = b.len
+		b.pos, b.len = 0, 0 // all  data is read.
+	} else if n > 0 {
+		b.move(n)
+	} else {
+		n = 0
+	}
+	return n
 }
 
 func (b *buffer) Read(dst []byte) (clen int) {
@@ -1,25 +1,54 @@
 package usnet
 
 import (
+	"bufio"
+	"errors"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"usnet/uscall"
 )
 
 type connCtx struct {
-	l   sync.RWMutex
-	seq int64
+	l    sync.RWMutex
+	seq  int64
+	iov  *uscall.IOVec // the slices of vectored io, created at the first use
+	grow int           // the size of buffer wanted by the next read, doubled when a read fills it
 	*buffer
 }
 
 type conn struct {
-	fd         *fdesc
-	rCtx, wCtx connCtx
-	// local addr
-	// remote addr
-	utrl UscallController
+	fd           *fdesc
+	rCtx, wCtx   connCtx
+	laddr, raddr net.Addr
+	utrl         UscallController
+	pool         *slabPool     // the pool of buffers, nil if they are not pooled
+	policy       *BufferPolicy // nil if the buffers are fixed
+	sq           *sendQueue    // nil if the writes are synchronous
+	recycled     int32
+
+	ops     uint64      // the count of io, the connection is idle if it is not changed
+	idleOps uint64      // the count seen by the last sweep
+	idle    *time.Timer // sweep the idle buffers, nil if they are not released
+	armed   int32       // the idle timer is set
+	polling int32       // the read waits for the readiness without the read buffer
+}
+
+// errIdle: the read waiting on the idle connection is interrupted to release the read buffer.
+var errIdle = errors.New("idle connection")
+
+// pollOnly: the any of read irq which waits for the readiness without reading.
+type pollOnly struct{}
+
+// opError: wrap the error like the net package, io.EOF is returned as it is.
+func (c *conn) opError(op string, err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return &net.OpError{Op: op, Net: "tcp", Source: c.laddr, Addr: c.raddr, Err: err}
 }
 
 func (c *conn) prepare(mode int) error {
@@ -32,15 +61,17 @@ func (c *conn) prepare(mode int) error {
 // Read reads data from the connection.
 // Read can be made to time out and return an error after a fixed
 // time limit; see SetDeadline and SetReadDeadline.
+// The goroutines blocked in Read are served in FIFO order.
 func (c *conn) Read(b []byte) (n int, err error) {
 	c.fd.incref('r')
-	defer c.fd.decref('r')
+	defer c.decref('r')
 
 	if err = c.prepare('r'); err != nil {
-		return 0, err
+		return 0, c.opError("read", err)
 	}
 
-	return c.safeRead(b)
+	n, err = c.safeRead(b)
+	return n, c.opError("read", err)
 }
 
 func (c *conn) safeRead(b []byte) (n int, err error) {
@@ -52,14 +83,14 @@ func (c *conn) safeRead(b []byte) (n int, err error) {
 		for buff, next := ctx.buffer, true; next; ctx.seq++ {
 			if buff.Len() <= 0 { // First, Fill read buffer if the buffer is empty.
 				var nread = 0
-				if nread, err = c.read(); err != nil {
+				if nread, err = c.read(nil); err != nil {
 					return
 				}
 				buff.setLen(nread)
 				next = false
 			}
 
-			if n += buff.Read(b); n == len(b) { // Second , read from the buffer.
+			if n += buff.Read(b[n:]); n == len(b) { // Second , read from the buffer.
 				next = false
 			}
 		}
@@ -68,50 +99,322 @@ func (c *conn) safeRead(b []byte) (n int, err error) {
 	return
 }
 
+// Peek returns the next n bytes without advancing the reader, it blocks until n bytes
+// are buffered. The bytes are a read-only view of the C read buffer, valid until the
+// next read call on the connection. If Peek returns fewer than n bytes, it also returns
+// an error explaining why, bufio.ErrBufferFull without blocking if n is larger than
+// the read buffer, which grows to the max of BufferPolicy.
+// The bytes are consumed by Release.
+func (c *conn) Peek(n int) (b []byte, err error) {
+	c.fd.incref('r')
+	defer c.decref('r')
+
+	if err = c.prepare('r'); err != nil {
+		return nil, c.opError("read", err)
+	}
+
+	ctx := &c.rCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	if n < 0 {
+		return nil, bufio.ErrNegativeCount
+	}
+
+	if c.reserve(ctx, n); n > len(ctx.shadow) {
+		return ctx.Data(), bufio.ErrBufferFull
+	}
+
+	err = c.fill(n)
+	if b = ctx.Data(); len(b) > n {
+		b = b[:n]
+	}
+	return b, c.opError("read", err)
+}
+
+// ReadView returns all the buffered bytes, it blocks to fill the read buffer if it is empty.
+// The bytes are a read-only view like Peek, they are consumed by Release.
+func (c *conn) ReadView() (b []byte, err error) {
+	c.fd.incref('r')
+	defer c.decref('r')
+
+	if err = c.prepare('r'); err != nil {
+		return nil, c.opError("read", err)
+	}
+
+	ctx := &c.rCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	err = c.fill(1)
+	return ctx.Data(), c.opError("read", err)
+}
+
+// Release advances the reader n bytes of the view returned by Peek or ReadView,
+// the view is invalid after Release.
+func (c *conn) Release(n int) {
+	ctx := &c.rCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	ctx.discard(n)
+}
+
+// Discard skips the next n bytes, it blocks until n bytes are skipped or an error.
+func (c *conn) Discard(n int) (discarded int, err error) {
+	c.fd.incref('r')
+	defer c.decref('r')
+
+	if err = c.prepare('r'); err != nil {
+		return 0, c.opError("read", err)
+	}
+
+	ctx := &c.rCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	if n < 0 {
+		return 0, bufio.ErrNegativeCount
+	}
+
+	for discarded < n && err == nil {
+		if err = c.fill(1); ctx.Len() > 0 {
+			discarded += ctx.discard(n - discarded)
+		}
+	}
+	return discarded, c.opError("read", err)
+}
+
+// fill: read until n bytes are buffered, the read buffer is tidied if the free space is not enough.
+func (c *conn) fill(n int) (err error) {
+	ctx := &c.rCtx
+	if err = c.fd.isOk('r'); err != nil {
+		return
+	}
+
+	for buff := ctx.buffer; buff.Len() < n; ctx.seq++ {
+		if c.reserve(ctx, n); buff.Space() < n-buff.Len() { // released by the idle read
+			buff.tidy()
+		}
+
+		var nread = 0
+		if nread, err = c.read(nil); err != nil {
+			return
+		}
+		buff.setLen(buff.Len() + nread)
+	}
+	return
+}
+
 // Write writes data to the connection.
 // Write can be made to time out and return an error after a fixed
 // time limit; see SetDeadline and SetWriteDeadline.
+// The goroutines blocked in Write are served in FIFO order.
+// With the send queue, Write queues the data and returns at once, see SendQueue.
 func (c *conn) Write(b []byte) (clen int, err error) {
 	c.fd.incref('w')
-	defer c.fd.decref('w')
+	defer c.decref('w')
 
 	if err = c.prepare('w'); err != nil {
-		return 0, err
+		return 0, c.opError("write", err)
 	}
 
-	return c.safeWrite(b)
+	if c.sq != nil {
+		clen, err = c.enqueue(b)
+	} else {
+		clen, err = c.safeWrite(b)
+	}
+	return clen, c.opError("write", err)
 }
 
-func (c *conn) read() (int, error) {
-	iReq := &irq{ih: &connHandler{conn: c}, reg: c.fd, sig: INT_SIG_INPUT}
+// Flush waits until the data queued by Write is written, it returns the error of writing
+// the send queue. It returns nil at once if the writes are synchronous.
+func (c *conn) Flush() error {
+	c.fd.incref('w')
+	defer c.decref('w')
 
-	c.fd.trap(iReq)         // enter trap
-	defer c.fd.untrap(iReq) // leave trap
+	if err := c.prepare('w'); err != nil {
+		return c.opError("flush", err)
+	}
+	return c.opError("flush", c.flush())
+}
+
+// enqueue: queue b to be written by the controller, the irq writing the queue is submitted
+// if it is not.
+func (c *conn) enqueue(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
 
-	if err := c.fd.isOk('r'); err != nil {
+	q := c.sq
+	q.l.Lock()
+	for q.err == nil && q.queued > 0 && q.queued+len(b) > q.HighWater {
+		if q.NonBlock {
+			q.l.Unlock()
+			return 0, ErrQueueFull
+		}
+		wait := q.waiter()
+		q.l.Unlock()
+		if err := c.await(wait); err != nil {
+			return 0, err
+		}
+		q.l.Lock()
+	}
+	if err := q.err; err != nil {
+		q.l.Unlock()
 		return 0, err
 	}
 
-	c.utrl.Serve(iReq)
-	err := c.fd.listen(iReq)
-	n, _ := iReq.any.(int)
-	return n, err
+	q.push(b)
+	submit := !q.draining
+	q.draining = true
+	q.l.Unlock()
+
+	if submit {
+		c.fd.incref('w') // the write deadline is bound while the queue is written, see written
+		iReq := newIrq((*connHandler)(c), c.fd, INT_SIG_OUTPUT)
+		iReq.any = q
+		c.fd.submit(iReq, 'w', q.notify, c.utrl)
+	}
+	return len(b), nil
 }
 
-func (c *conn) write() (int, error) {
-	iReq := &irq{ih: &connHandler{conn: c}, reg: c.fd, sig: INT_SIG_OUTPUT}
+// flush: wait until the send queue is written, nil if there is no queue.
+func (c *conn) flush() error {
+	q := c.sq
+	if q == nil {
+		return nil
+	}
 
-	c.fd.trap(iReq)         // enter trap
-	defer c.fd.untrap(iReq) // leave trap
+	for {
+		q.l.Lock()
+		if err := q.err; err != nil || !q.draining {
+			q.l.Unlock()
+			return err
+		}
+		wait := q.waiter()
+		q.l.Unlock()
+		if err := c.await(wait); err != nil {
+			return err
+		}
+	}
+}
 
-	if err := c.fd.isOk('w'); err != nil {
+// await: wait for the send queue drains, the close and deadline are checked every pressureCheck.
+func (c *conn) await(wait <-chan struct{}) error {
+	t := time.NewTimer(pressureCheck)
+	defer t.Stop()
+	for {
+		select {
+		case <-wait:
+			return nil
+		case <-t.C:
+			if err := c.fd.isOk('w'); err != nil {
+				return err
+			}
+			t.Reset(pressureCheck)
+		}
+	}
+}
+
+// written: the notify of the irq writing the send queue, the queue is broken by its error.
+// The ref taken by enqueue is dropped, the write deadline no longer binds to the queue.
+func (c *conn) written(iReq *irq) {
+	if err := iReq.err; err != nil {
+		q := c.sq
+		q.l.Lock()
+		q.fail(err)
+		q.l.Unlock()
+	}
+	iReq.release()
+	c.decref('w')
+}
+
+// setSendQueue: write asynchronously through the send queue if q is not nil.
+func (c *conn) setSendQueue(q *SendQueue, pool *slabPool) {
+	if q != nil {
+		c.sq = &sendQueue{SendQueue: q, pool: pool}
+		c.sq.notify = c.written
+	}
+}
+
+// read: read into the free space of read buffer, or iov if it is not nil. The read waiting on
+// the idle connection is interrupted by sweep, then it waits for the readiness without the
+// read buffer, which is reserved again before the next read.
+func (c *conn) read(iov *uscall.IOVec) (n int, err error) {
+	var src interface{} // nil or iov, not the typed nil
+	if iov != nil {
+		src = iov
+	}
+
+	// the new connection waits for the memory served ones release.
+	if atomic.LoadUint64(&c.ops) == 0 {
+		if err = memory.wait(false, c.fd, 'r'); err != nil {
+			return
+		}
+	}
+
+	ctx, space := &c.rCtx, 0
+	for {
+		if iov == nil {
+			c.reserve(ctx, ctx.grow)
+			space = ctx.Space()
+		}
+		atomic.AddUint64(&c.ops, 1)
+		if n, err = c.serve(INT_SIG_INPUT, src); err != errIdle {
+			break
+		}
+
+		if ctx.Len() <= 0 {
+			c.drop(ctx)
+		}
+		atomic.StoreInt32(&c.polling, 1)
+		for err = errIdle; err == errIdle; {
+			_, err = c.serve(INT_SIG_INPUT, pollOnly{})
+		}
+		atomic.StoreInt32(&c.polling, 0)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if iov == nil && n == space { // filled, grow the buffer at the next read
+		ctx.grow = 2 * len(ctx.shadow)
+	}
+	return
+}
+
+// serve: serve the irq of sig with any and wait for it.
+func (c *conn) serve(sig INT_SIGNAL, any interface{}) (int, error) {
+	iReq := newIrq((*connHandler)(c), c.fd, sig)
+	defer iReq.release()
+	iReq.any = any
+
+	c.fd.trap(iReq) // enter trap
+
+	_, _, mode := (*connHandler)(c).waits(iReq)
+	if err := c.fd.isOk(mode); err != nil {
+		c.fd.untrap(iReq)
 		return 0, err
 	}
 
-	c.utrl.Serve(iReq)
+	c.fd.serve(iReq, c.utrl)
 	err := c.fd.listen(iReq)
-	n, _ := iReq.any.(int)
-	return n, err
+	c.fd.untrap(iReq) // leave trap
+	if inflightIO && !iReq.reaped.Load() {
+		err = c.settle(iReq)
+	}
+	return iReq.n, err
+}
+
+// write: write the write buffer, or src if it is not nil: the zero-copy buffer or iovec.
+// The data in the send queue is written first.
+func (c *conn) write(src interface{}) (int, error) {
+	if err := c.flush(); err != nil {
+		return 0, err
+	}
+	atomic.AddUint64(&c.ops, 1)
+	return c.serve(INT_SIG_OUTPUT, src)
 }
 
 func (c *conn) safeWrite(b []byte) (clen int, err error) {
@@ -120,6 +423,7 @@ func (c *conn) safeWrite(b []byte) (clen int, err error) {
 	defer ctx.l.Unlock()
 
 	if err = c.fd.isOk('w'); err == nil {
+		c.reserve(ctx, len(b))
 		buff := ctx.buffer.setPos(0).setLen(0) // clean write buffer
 		for dataLen := len(b); clen < dataLen && err == nil; ctx.seq++ {
 			if len(b) > 0 {
@@ -127,7 +431,7 @@ func (c *conn) safeWrite(b []byte) (clen int, err error) {
 			}
 
 			var nwrite int
-			if nwrite, err = c.write(); nwrite > 0 { // Second: write data
+			if nwrite, err = c.write(nil); nwrite > 0 { // Second: write data
 				clen += nwrite
 				buff.move(nwrite)
 			}
@@ -137,26 +441,530 @@ func (c *conn) safeWrite(b []byte) (clen int, err error) {
 	return
 }
 
+/*
+ZCBuffer is the send buffer of WriteZC, it is written without the copy into the
+write buffer of connection.
+
+	On f-stack it is a chain of mbufs, which is filled by Write only, Bytes returns nil.
+	On the kernel and netstack backends it is a C buffer, Bytes returns it to be filled
+	in place. All Len bytes are written.
+
+	Ownership: the buffer belongs to the caller until it is passed to WriteZC, which takes
+	it whatever the result and frees it, so it must not be used after that. The buffer
+	not written is released by Free, the mbufs of f-stack on the controller thread.
+*/
+type ZCBuffer struct {
+	zc *uscall.ZCBuf
+	c  *conn
+}
+
+// Bytes returns the buffer to fill in place, nil if the buffer is not addressable.
+func (b *ZCBuffer) Bytes() []byte {
+	return b.zc.Bytes()
+}
+
+// Write copies p into the buffer, io.ErrShortWrite is returned if the buffer is full.
+func (b *ZCBuffer) Write(p []byte) (int, error) {
+	return b.zc.Write(p)
+}
+
+func (b *ZCBuffer) Len() int {
+	return b.zc.Len()
+}
+
+func (b *ZCBuffer) Free() {
+	if !uscall.ZCGetOnLoop {
+		b.zc.Free()
+		return
+	}
+
+	// freed where the mbufs are allocated, nothing to wait.
+	iReq := newIrq(&zcHandler{zc: b.zc}, nil, INT_SIG_EXP)
+	b.c.utrl.Serve(iReq)
+	iReq.release()
+}
+
+// ZCBuffer gets a send buffer of size bytes for WriteZC, see ZCBuffer.
+func (c *conn) ZCBuffer(size int) (*ZCBuffer, error) {
+	if !uscall.ZCGetOnLoop {
+		if err := c.fd.isOk('w'); err != nil {
+			return nil, c.opError("write", err)
+		}
+		zc, err := uscall.UscallZCGet(size)
+		if err != nil {
+			return nil, c.opError("write", err)
+		}
+		return &ZCBuffer{zc: zc, c: c}, nil
+	}
+
+	iReq := newIrq(&zcHandler{fd: c.fd, size: size}, nil, INT_SIG_EXP)
+	defer iReq.release()
+
+	c.fd.trap(iReq)
+	defer c.fd.untrap(iReq)
+
+	if err := c.fd.isOk('w'); err != nil {
+		return nil, c.opError("write", err)
+	}
+
+	c.fd.serve(iReq, c.utrl)
+	if err := c.fd.listen(iReq); err != nil {
+		return nil, c.opError("write", err)
+	}
+	return &ZCBuffer{zc: iReq.any.(*uscall.ZCBuf), c: c}, nil
+}
+
+// WriteZC writes the whole buffer got by ZCBuffer, it is taken whatever the result.
+// WriteZC can be made to time out like Write.
+func (c *conn) WriteZC(b *ZCBuffer) (n int, err error) {
+	defer b.Free() // the written mbufs are owned by f-stack, not freed again
+	c.fd.incref('w')
+	defer c.decref('w')
+
+	if err = c.prepare('w'); err != nil {
+		return 0, c.opError("write", err)
+	}
+
+	n, err = c.safeWriteZC(b.zc)
+	return n, c.opError("write", err)
+}
+
+func (c *conn) safeWriteZC(zc *uscall.ZCBuf) (clen int, err error) {
+	ctx := &c.wCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	if err = c.fd.isOk('w'); err == nil {
+		for ; zc.Remaining() > 0 && err == nil; ctx.seq++ {
+			var nwrite int
+			nwrite, err = c.write(zc)
+			clen += nwrite
+		}
+	}
+
+	return
+}
+
+// WriteBuffers writes the buffers of v in order, like net.Buffers.WriteTo with the writev.
+// The buffers are written directly by the writev of IOVMax buffers at most each call, so the
+// headers and body go out in one call, by the sendmsg sqe on io_uring. v is consumed as net.Buffers.WriteTo does.
+func (c *conn) WriteBuffers(v *net.Buffers) (n int64, err error) {
+	c.fd.incref('w')
+	defer c.decref('w')
+
+	if err = c.prepare('w'); err != nil {
+		return 0, c.opError("writev", err)
+	}
+
+	if c.sq != nil { // queued in order
+		for i := 0; i < len(*v) && err == nil; i++ {
+			var nwrite int
+			nwrite, err = c.enqueue((*v)[i])
+			n += int64(nwrite)
+		}
+		consumeBuffers(v, n)
+		return n, c.opError("writev", err)
+	}
+	n, err = c.safeWriteBuffers(v)
+	return n, c.opError("writev", err)
+}
+
+func (c *conn) safeWriteBuffers(v *net.Buffers) (n int64, err error) {
+	ctx := &c.wCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	if err = c.fd.isOk('w'); err != nil {
+		return
+	}
+
+	if ctx.iov == nil {
+		ctx.iov = uscall.NewIOVec(uscall.IOVMax)
+	}
+	defer ctx.iov.Reset()
+
+	for consumeBuffers(v, 0); len(*v) > 0 && err == nil; ctx.seq++ {
+		ctx.iov.Reset()
+		ctx.iov.AddBytes(*v)
+
+		var nwrite int
+		if nwrite, err = c.write(ctx.iov); nwrite > 0 {
+			n += int64(nwrite)
+			consumeBuffers(v, int64(nwrite))
+		}
+	}
+	return
+}
+
+// consumeBuffers: drop n bytes from the head of v, and the empty buffers.
+func consumeBuffers(v *net.Buffers, n int64) {
+	for len(*v) > 0 {
+		ln0 := int64(len((*v)[0]))
+		if ln0 > n {
+			(*v)[0] = (*v)[0][n:]
+			return
+		}
+		n -= ln0
+		(*v)[0] = nil
+		*v = (*v)[1:]
+	}
+}
+
+// ReadBuffers reads into the buffers of v in order by one readv, like Read it returns
+// after one read. The data buffered is copied first without the readv.
+func (c *conn) ReadBuffers(v net.Buffers) (n int64, err error) {
+	c.fd.incref('r')
+	defer c.decref('r')
+
+	if err = c.prepare('r'); err != nil {
+		return 0, c.opError("readv", err)
+	}
+
+	n, err = c.safeReadBuffers(v)
+	return n, c.opError("readv", err)
+}
+
+func (c *conn) safeReadBuffers(v net.Buffers) (n int64, err error) {
+	ctx := &c.rCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	if err = c.fd.isOk('r'); err != nil {
+		return
+	}
+
+	if ctx.Len() <= 0 {
+		if ctx.iov == nil {
+			ctx.iov = uscall.NewIOVec(uscall.IOVMax)
+		}
+		defer ctx.iov.Reset()
+
+		if ctx.iov.AddBytes(v); ctx.iov.Len() == 0 {
+			return
+		}
+
+		var nread int
+		nread, err = c.read(ctx.iov)
+		ctx.seq++
+		return int64(nread), err
+	}
+
+	for i := 0; i < len(v) && ctx.Len() > 0; i++ {
+		n += int64(ctx.Read(v[i]))
+	}
+	return
+}
+
+// ReadFrom implements io.ReaderFrom, r is read into the write buffer directly, then the
+// buffer is written. If r is a usnet connection, its read buffer is swapped with the
+// write buffer instead of the copy, see WriteTo.
+func (c *conn) ReadFrom(r io.Reader) (n int64, err error) {
+	if src := connOf(r); src != nil && !inflightIO {
+		return splice(c, src)
+	}
+
+	c.fd.incref('w')
+	defer c.decref('w')
+
+	if err = c.prepare('w'); err != nil {
+		return 0, c.opError("readfrom", err)
+	}
+
+	n, err = c.safeReadFrom(r)
+	return n, c.opError("readfrom", err)
+}
+
+func (c *conn) safeReadFrom(r io.Reader) (n int64, err error) {
+	ctx := &c.wCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	if err = c.fd.isOk('w'); err != nil {
+		return
+	}
+
+	c.reserve(ctx, 0)
+	for buff := ctx.buffer; ; {
+		nread, rerr := r.Read(buff.shadow)
+		buff.setPos(0).setLen(nread)
+
+		nwrite, werr := c.drain()
+		if n += nwrite; werr != nil {
+			return n, werr
+		} else if rerr != nil {
+			if rerr == io.EOF {
+				rerr = nil
+			}
+			return n, rerr
+		}
+	}
+}
+
+// WriteTo implements io.WriterTo, the read buffer is written to w directly. If w is a
+// usnet connection, the read buffer is swapped with its write buffer instead of the copy.
+// On io_uring, see inflightIO, the buffers are not swapped, they are copied like any io.Writer.
+func (c *conn) WriteTo(w io.Writer) (n int64, err error) {
+	if dst := connOf(w); dst != nil && !inflightIO {
+		return splice(dst, c)
+	}
+
+	c.fd.incref('r')
+	defer c.decref('r')
+
+	if err = c.prepare('r'); err != nil {
+		return 0, c.opError("writeto", err)
+	}
+
+	n, err = c.safeWriteTo(w)
+	return n, c.opError("writeto", err)
+}
+
+func (c *conn) safeWriteTo(w io.Writer) (n int64, err error) {
+	ctx := &c.rCtx
+	ctx.l.Lock()
+	defer ctx.l.Unlock()
+
+	if err = c.fd.isOk('r'); err != nil {
+		return
+	}
+
+	for buff := ctx.buffer; ; ctx.seq++ {
+		if buff.Len() <= 0 {
+			var nread int
+			if nread, err = c.read(nil); err == io.EOF {
+				return n, nil
+			} else if err != nil {
+				return
+			}
+			buff.setLen(nread)
+		}
+
+		nwrite, err := w.Write(buff.Data())
+		if n += int64(buff.discard(nwrite)); err != nil {
+			return n, err
+		}
+	}
+}
+
+// splice: copy src to dst until EOF, the read buffer of src is filled, then it is swapped
+// with the drained write buffer of dst and written, the data is not copied.
+func splice(dst, src *conn) (n int64, err error) {
+	src.fd.incref('r')
+	defer src.decref('r')
+	dst.fd.incref('w')
+	defer dst.decref('w')
+
+	if err = src.prepare('r'); err != nil {
+		return 0, src.opError("read", err)
+	} else if err = dst.prepare('w'); err != nil {
+		return 0, dst.opError("write", err)
+	}
+
+	rctx, wctx := &src.rCtx, &dst.wCtx
+	rctx.l.Lock()
+	defer rctx.l.Unlock()
+	wctx.l.Lock()
+	defer wctx.l.Unlock()
+
+	if err = src.fd.isOk('r'); err != nil {
+		return 0, src.opError("read", err)
+	} else if err = dst.fd.isOk('w'); err != nil {
+		return 0, dst.opError("write", err)
+	}
+
+	for ; ; rctx.seq++ {
+		if rctx.Len() <= 0 {
+			var nread int
+			if nread, err = src.read(nil); err == io.EOF {
+				return n, nil
+			} else if err != nil {
+				return n, src.opError("read", err)
+			}
+			rctx.setLen(nread)
+		}
+
+		rctx.buffer, wctx.buffer = wctx.setPos(0).setLen(0), rctx.buffer
+		nwrite, err := dst.drain()
+		if n += nwrite; err != nil {
+			return n, dst.opError("write", err)
+		}
+	}
+}
+
+// drain: write the write buffer until it is empty.
+func (c *conn) drain() (n int64, err error) {
+	ctx := &c.wCtx
+	for buff := ctx.buffer; buff.Len() > 0 && err == nil; ctx.seq++ {
+		var nwrite int
+		if nwrite, err = c.write(nil); nwrite > 0 {
+			n += int64(nwrite)
+			buff.move(nwrite)
+		}
+	}
+	return
+}
+
+// connOf: the usnet connection of x, nil if it is not.
+func connOf(x interface{}) *conn {
+	switch c := x.(type) {
+	case *TCPConn:
+		return &c.conn
+	case *conn:
+		return c
+	}
+	return nil
+}
+
 // Close closes the connection.
 // Any blocked Read or Write operations will be unblocked and return errors.
 func (c *conn) Close() error {
-	iReq := &irq{ih: &closeHandler{fd: c.fd}}
+	iReq := newIrq(&closeHandler{fd: c.fd}, nil, INT_SIG_EXP)
+	defer iReq.release()
 
 	c.fd.trap(iReq)
 	defer c.fd.untrap(iReq)
 
-	c.utrl.Serve(iReq)
-	return c.fd.listen(iReq)
+	c.fd.serve(iReq, c.utrl)
+	err := c.fd.listen(iReq)
+	if c.idle != nil {
+		c.idle.Stop()
+	}
+	c.recycle()
+	return c.opError("close", err)
+}
+
+// decref: leave the op, the buffers are recycled by the last op leaving the closed connection.
+func (c *conn) decref(mode int) {
+	c.fd.decref(mode)
+	c.recycle()
+}
+
+// recycle: put the buffers back to the pool once the connection is closed and no op is running,
+// the views borrowed by Peek and ReadView are invalid after that.
+func (c *conn) recycle() {
+	if c.pool == nil || !c.fd.status.has(CLOSED) || c.fd.refs() > 0 ||
+		!atomic.CompareAndSwapInt32(&c.recycled, 0, 1) {
+		return
+	}
+
+	for _, ctx := range [...]*connCtx{&c.rCtx, &c.wCtx} {
+		ctx.l.Lock()
+		c.pool.put(ctx.buffer)
+		ctx.buffer = emptyBuffer() // no io is done after closed
+		ctx.l.Unlock()
+	}
+	c.releaseQueue()
+}
+
+// releaseQueue: put the buffers of send queue back if it is not being written.
+func (c *conn) releaseQueue() {
+	if q := c.sq; q != nil {
+		q.l.Lock()
+		q.release()
+		q.l.Unlock()
+	}
+}
+
+// setBuffers: take the buffers from pool sized by policy.
+func (c *conn) setBuffers(pool *slabPool, policy *BufferPolicy) {
+	c.pool, c.policy = pool, policy
+	c.rCtx.buffer, c.wCtx.buffer = emptyBuffer(), emptyBuffer()
+	if policy.Idle > 0 {
+		c.idle = time.AfterFunc(policy.Idle, c.sweep)
+		c.armed = 1
+	}
+	c.reserve(&c.rCtx, 0)
+	c.reserve(&c.wCtx, 0)
+}
+
+// reserve: take the buffer of ctx if it is released, and grow it to hold size bytes up to
+// the max of policy, the data unread is kept. It is called with the lock of ctx.
+func (c *conn) reserve(ctx *connCtx, size int) {
+	p := c.policy
+	if p == nil || c.fd.status.has(CLOSED) { // recycled by Close
+		return
+	} else if size = max(size, p.Min); size > p.Max {
+		size = p.Max
+	}
+	if ctx.entity != nil && size <= len(ctx.shadow) {
+		return
+	}
+
+	b := c.pool.get(size)
+	b.Append(ctx.Data())
+	ctx.swap(b)
+	c.pool.put(b) // the memory replaced, nothing if it is released
+	c.arm()
+}
+
+// arm: set the idle timer if it is not set.
+func (c *conn) arm() {
+	if c.idle != nil && atomic.CompareAndSwapInt32(&c.armed, 0, 1) {
+		c.idle.Reset(c.policy.Idle)
+	}
+}
+
+// sweep: release the buffers if there is no io since the last sweep, it runs on the idle timer.
+// The read waiting for data holds the read buffer, it is interrupted to release the buffer itself.
+func (c *conn) sweep() {
+	if c.fd.status.has(CLOSED) { // recycled by Close
+		return
+	} else if ops := atomic.LoadUint64(&c.ops); ops != atomic.SwapUint64(&c.idleOps, ops) {
+		c.idle.Reset(c.policy.Idle)
+		return
+	}
+
+	atomic.StoreInt32(&c.armed, 0)
+	c.releaseQueue()
+	w := c.release(&c.wCtx)
+	r := atomic.LoadInt32(&c.polling) == 1 || c.release(&c.rCtx)
+	if !r {
+		m := errorWrapMf(listenerMf(claimMf(INT_SIG_INPUT)), errIdle)
+		c.fd.irqHandler.interrupt(INT_SRC_TIMER, m, true)
+	}
+	if !r || !w { // in use, check it later
+		c.arm()
+	}
+}
+
+// release: release the buffer of ctx if it is not in use, return true if it is released.
+func (c *conn) release(ctx *connCtx) bool {
+	if !ctx.l.TryLock() {
+		return false
+	}
+	defer ctx.l.Unlock()
+
+	if ctx == &c.wCtx || ctx.Len() <= 0 {
+		c.drop(ctx)
+	}
+	return ctx.entity == nil
+}
+
+// drop: put the buffer of ctx back, the data is dropped. It is called with the lock of ctx.
+func (c *conn) drop(ctx *connCtx) {
+	if c.policy == nil || ctx.entity == nil {
+		return
+	}
+	b := emptyBuffer()
+	b.swap(ctx.buffer)
+	c.pool.put(b)
+	ctx.grow = 0
+}
+
+// PeerClosed reports whether the peer has closed or reset the connection,
+// the idle connections are watched too, so the pools could evict the dead ones.
+func (c *conn) PeerClosed() bool {
+	return c.fd.status.has(RDHUP | HUP)
 }
 
 // LocalAddr returns the local network address, if known.
 func (c *conn) LocalAddr() net.Addr {
-	return nil
+	return c.laddr
 }
 
 // RemoteAddr returns the remote network address, if known.
 func (c *conn) RemoteAddr() net.Addr {
-	return nil
+	return c.raddr
 }
 
 // SetDeadline sets the read and write deadlines associated
@@ -181,15 +989,19 @@ func (c *conn) RemoteAddr() net.Addr {
 //
 // A zero value for t means I/O operations will not time out.
 func (c *conn) SetDeadline(t time.Time) error {
-	c.SetReadDeadline(t)
-	c.SetWriteDeadline(t)
-	return nil
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
 }
 
 // SetReadDeadline sets the deadline for future Read calls
 // and any currently-blocked Read call.
 // A zero value for t means Read will not time out.
 func (c *conn) SetReadDeadline(t time.Time) error {
+	if c.fd.status.has(CLOSED) {
+		return c.opError("set", net.ErrClosed)
+	}
 	c.fd.setReadDeadline(t)
 	return nil
 }
@@ -200,38 +1012,39 @@ func (c *conn) SetReadDeadline(t time.Time) error {
 // some of the data was successfully written.
 // A zero value for t means Write will not time out.
 func (c *conn) SetWriteDeadline(t time.Time) error {
+	if c.fd.status.has(CLOSED) {
+		return c.opError("set", net.ErrClosed)
+	}
 	c.fd.setWriteDeadline(t)
 	return nil
 }
 
-// connHandler implement UscallHandler
-type connHandler struct {
-	*conn
-}
+// connHandler implement UscallHandler, converted from conn without allocation.
+type connHandler conn
 
-func (c *connHandler) Error(iReq *irq, err error) {
-	var ref *int64
-	var event uint32
+// waits: the waiting counter and the epoll event of the irq.
+func (c *connHandler) waits(iReq *irq) (ref *int64, event uint32, mode int) {
+	if iReq.sig == INT_SIG_OUTPUT {
+		return &c.fd.wwaits, uscall.EPOLLOUT, 'w'
+	}
+	return &c.fd.rwaits, uscall.EPOLLIN, 'r'
+}
 
-	switch iReq.sig {
-	case INT_SIG_INPUT:
-		{
-			ref, event = &c.fd.rwaits, (uscall.EPOLLIN)
-		}
-	case INT_SIG_OUTPUT:
-		{
-			ref, event = &c.fd.wwaits, uscall.EPOLLOUT
-		}
-	default:
+// done: write the result of running irq and wake the waiter.
+func (c *connHandler) done(iReq *irq, n int, err error) {
+	iReq.n, iReq.err = n, err
+	if ref, event, _ := c.waits(iReq); iReq.retry > 0 {
+		c.fd.netpoller_delete_event(ref, event)
 	}
+	c.fd.interrupt(INT_SRC_POLLER, doneMf(iReq), false)
+}
 
-	iReq.err = err
-	if iReq.retry > 0 {
+func (c *connHandler) Error(iReq *irq, err error) {
+	if iReq.begin() {
+		c.done(iReq, 0, err)
+	} else if ref, event, _ := c.waits(iReq); iReq.retry > 0 {
 		c.fd.netpoller_delete_event(ref, event)
 	}
-	c.fd.interrupt(INT_SRC_POLLER, func(i *irq) bool {
-		return i.seq == iReq.seq
-	}, false)
 }
 
 func (c *connHandler) Handle(iReq *irq) (callback bool) {
@@ -239,69 +1052,206 @@ func (c *connHandler) Handle(iReq *irq) (callback bool) {
 		return
 	}
 
-	var ref *int64
-	var event uint32
-	callback = true
+	if ready, done := c.enter(iReq); !ready {
+		return done
+	}
 
-	switch iReq.sig {
-	case INT_SIG_INPUT:
-		{
-			ref, event = &c.fd.rwaits, (uscall.EPOLLIN)
-			if err := c.fd.isOk('r'); err != nil {
-				iReq.err = err
-				goto connHandleEnd
-			}
+	n, err := c.io(iReq)
+	return c.leave(iReq, n, err)
+}
 
-			if nread, err := c.fd.read(c.rCtx.entity); err == syscall.EAGAIN {
-				callback = false
-			} else {
-				iReq.any, iReq.err = nread, err
-			}
+// io: read into the read buffer, or write the write buffer, or the zero-copy buffer, iovec and op of irq.
+func (c *connHandler) io(iReq *irq) (int, error) {
+	if iReq.sig != INT_SIG_OUTPUT {
+		switch src := iReq.any.(type) {
+		case *uscall.IOVec:
+			return c.fd.readResult(uscall.UscallReadv(c.fd.fd, src))
+		case pollOnly:
+			return 0, nil
+		case *Op:
+			return c.transfer(src)
 		}
-	case INT_SIG_OUTPUT:
-		{
-			ref, event = &c.fd.wwaits, uscall.EPOLLOUT
-			if err := c.fd.isOk('w'); err != nil {
-				iReq.err = err
-				goto connHandleEnd
-			}
+		return c.fd.read(c.rCtx.CSpace())
+	}
 
-			if nwrite, err := c.fd.write(c.wCtx.CData()); err == syscall.EAGAIN { // Second: write data
-				callback = false
-			} else {
-				iReq.any, iReq.err = nwrite, err
-			}
+	switch src := iReq.any.(type) {
+	case *sendQueue:
+		return c.send(src)
+	case *Op:
+		return c.transfer(src)
+	case *uscall.ZCBuf:
+		return c.fd.writeResult(uscall.UscallZCWrite(c.fd.fd, src))
+	case *uscall.IOVec:
+		return c.fd.writeResult(uscall.UscallWritev(c.fd.fd, src))
+	}
+	return c.fd.write(c.wCtx.CData()) // Second: write data
+}
+
+// send: write the send queue until it is empty, the irq waits for the writable fd on EAGAIN.
+func (c *connHandler) send(q *sendQueue) (n int, err error) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	defer q.signal() // the writers waiting the space and flushers
+
+	for head := q.head(); head != nil; head = q.head() {
+		cs := head.CData()
+		q.l.Unlock()
+		nwrite, err := c.fd.write(cs)
+		q.l.Lock()
+		if err != nil {
+			return n, err
 		}
-	default:
+		n += nwrite
+		q.pop(nwrite)
+	}
+	q.drained()
+	return n, nil
+}
+
+// batch: add the io of irq into the batch, the irq is handled as Handle if it is not queued.
+func (c *connHandler) batch(b *uscall.Batch, iReq *irq) (queued, done bool) {
+	if ready, done := c.enter(iReq); !ready {
+		return false, done
+	} else if iReq.any != nil { // the zero-copy buffer and iovec are done alone
+		n, err := c.io(iReq)
+		return false, c.leave(iReq, n, err)
+	}
+
+	if iReq.sig == INT_SIG_OUTPUT {
+		b.Write(c.fd.fd, c.wCtx.CData())
+	} else {
+		b.Read(c.fd.fd, c.rCtx.CSpace())
+	}
+	return true, false
+}
+
+// batched: the result of the io in batch.
+func (c *connHandler) batched(iReq *irq, n int, err error) bool {
+	if iReq.sig == INT_SIG_OUTPUT {
+		n, err = c.fd.writeResult(n, err)
+	} else {
+		n, err = c.fd.readResult(n, err)
 	}
+	return c.leave(iReq, n, err)
+}
 
-connHandleEnd:
-	if callback {
+// enter: begin the irq, ready is true if the io should be done, or the irq is handled and done is returned.
+func (c *connHandler) enter(iReq *irq) (ready, done bool) {
+	ref, event, mode := c.waits(iReq)
+	if !iReq.begin() { // completed by the timer or close, drop it.
 		if iReq.retry > 0 {
 			c.fd.netpoller_delete_event(ref, event)
 		}
-		c.fd.interrupt(INT_SRC_POLLER, func(i *irq) bool {
-			return i.seq == iReq.seq
-		}, false)
-	} else {
-		if iReq.retry == 0 {
-			c.fd.netpoller_add_event(ref, event)
-		}
-		iReq.retry++
+		return false, true
 	}
-	return
+
+	if err := c.fd.isOk(mode); err != nil {
+		c.done(iReq, 0, err)
+		return false, true
+	}
+
+	// the readiness is assumed in level-triggered mode, the poll waits for the event.
+	if _, poll := iReq.any.(pollOnly); !c.fd.ready(c.readiness(iReq)) || poll && iReq.retry == 0 && !c.fd.edge() {
+		return false, c.wait(iReq)
+	}
+	return true, false
+}
+
+// leave: finish the io of running irq, return false if it waits for the readiness.
+func (c *connHandler) leave(iReq *irq, n int, err error) bool {
+	if err != syscall.EAGAIN {
+		c.done(iReq, n, err)
+		return true
+	}
+	c.fd.drained(c.readiness(iReq))
+	return c.wait(iReq)
+}
+
+// wait: pause the irq until the readiness, return true if it is done meanwhile.
+func (c *connHandler) wait(iReq *irq) bool {
+	ref, event, mode := c.waits(iReq)
+	if iReq.retry == 0 {
+		c.fd.netpoller_add_event(ref, event)
+	}
+	iReq.retry++
+	iReq.pause()
+
+	// the running irq is skipped by the timer and close, check them again.
+	if err := c.fd.isOk(mode); err != nil && iReq.begin() {
+		c.done(iReq, 0, err)
+		return true
+	}
+	return false
+}
+
+// readiness: the status of fd the irq waits for.
+func (c *connHandler) readiness(iReq *irq) FD_STATUS {
+	if iReq.sig == INT_SIG_OUTPUT {
+		return WRITEABLE
+	}
+	return READABLE
 }
 
 type closeHandler struct {
-	fd *fdesc
+	fd     *fdesc
+	poller *netpoller // closed with the listener fd on controller thread
 }
 
 func (ch *closeHandler) Handle(iReq *irq) bool {
-	ch.fd.close()
-	ch.fd.interrupt(INT_SRC_POLLER, func(i *irq) bool {
-		return true // match all interrupt request.
-	}, true)
+	if ch.fd.status.has(CLOSED) {
+		iReq.err = net.ErrClosed
+	} else {
+		iReq.err = ch.fd.close()
+		if ch.poller != nil {
+			ch.poller.close()
+		}
+	}
+	ch.fd.interrupt(INT_SRC_POLLER, closedMf(iReq), true)
 	return true
 }
 
+// closedMf: match all interrupt requests, the pending io returns net.ErrClosed,
+// the running io is left to the controller.
+func closedMf(iReq *irq) matchFunc {
+	return matchFunc{sig: INT_SIG_ANY, match: func(i *irq) bool {
+		if i == iReq {
+			return true
+		} else if i.claim() {
+			i.err = net.ErrClosed
+			return true
+		}
+		return false
+	}}
+}
+
 func (ch *closeHandler) Error(iReq *irq, err error) {}
+
+// zcHandler: get or free the zero-copy buffer on controller thread, where the mbufs of f-stack are allocated.
+type zcHandler struct {
+	fd   *fdesc
+	size int
+	zc   *uscall.ZCBuf // the buffer to free, nobody waits for it
+}
+
+func (h *zcHandler) Handle(iReq *irq) bool {
+	if h.zc != nil {
+		h.zc.Free()
+	} else if iReq.begin() { // not interrupted by close
+		if zc, err := uscall.UscallZCGet(h.size); err != nil {
+			iReq.err = err
+		} else {
+			iReq.any = zc
+		}
+		h.fd.interrupt(INT_SRC_POLLER, doneMf(iReq), false)
+	}
+	return true
+}
+
+func (h *zcHandler) Error(iReq *irq, err error) {
+	if h.zc != nil {
+		h.zc.Free()
+	} else if iReq.begin() {
+		iReq.err = err
+		h.fd.interrupt(INT_SRC_POLLER, doneMf(iReq), false)
+	}
+}
@@ -1,11 +1,16 @@
-//go:build syscall
-// +build syscall
+//go:build syscall || netstack
+// +build syscall netstack
 
 package usnet
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"io"
+	"net"
 	"os"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -127,6 +132,34 @@ func TestConnEcho(t *testing.T) {
 	}
 }
 
+func TestConnReadDeadlineData(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+	defer conn.Close()
+
+	// the data arriving while the reads time out is neither lost nor read twice.
+	data := bytes.Repeat([]byte("data_xxxx"), 2000)
+	go func() {
+		for b := data; len(b) > 0; b = b[min(len(b), 100):] {
+			client.Write(b[:min(len(b), 100)])
+			time.Sleep(50 * time.Microsecond)
+		}
+	}()
+
+	output := make([]byte, 0, len(data))
+	b := make([]byte, 256)
+	for len(output) < len(data) {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Microsecond))
+		n, err := conn.Read(b)
+		if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatal(err)
+		}
+		output = append(output, b[:n]...)
+	}
+	assert.Equal(t, data, output)
+}
+
 func TestConnReadDeadlineException(t *testing.T) {
 	client := testDail(t)
 	conn := testNewConn(testAccept(t))
@@ -136,13 +169,13 @@ func TestConnReadDeadlineException(t *testing.T) {
 	data := make([]byte, 1024)
 
 	_, err := conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// 2. update less than now
 	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
 	go conn.SetReadDeadline(time.Now().Add(-1 * time.Second))
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// 3. return EOF error
 	client.Close()
@@ -151,10 +184,10 @@ func TestConnReadDeadlineException(t *testing.T) {
 	assert.EqualError(t, err, io.EOF.Error())
 	assert.Equal(t, n, 0)
 
-	// 4. return EINVILA
+	// 4. return net.ErrClosed
 	conn.Close()
 	n, err = conn.Read(data)
-	assert.EqualError(t, err, syscall.EINVAL.Error())
+	assert.ErrorIs(t, err, net.ErrClosed)
 	assert.Equal(t, n, 0)
 }
 
@@ -170,11 +203,11 @@ func TestConnReadDeadline(t *testing.T) {
 	data := make([]byte, 1024)
 
 	_, err := conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// return immediatly
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// clean dealine
 	conn.SetReadDeadline(time.Time{})
@@ -185,27 +218,27 @@ func TestConnReadDeadline(t *testing.T) {
 		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 	}()
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// return immediatly
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// 3. update dealine in pending io immediately
 	conn.SetReadDeadline(time.Time{})
 	go conn.SetReadDeadline(time.Now().Add(time.Second))
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// 4. very short duration
 	conn.SetDeadline(time.Now().Add(time.Millisecond))
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	conn.SetDeadline(time.Time{})
 	go conn.SetDeadline(time.Now().Add(time.Millisecond))
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 }
 
 func TestConnReadDeadlineMultiConn(t *testing.T) {
@@ -225,7 +258,7 @@ func TestConnReadDeadlineMultiConn(t *testing.T) {
 		wait <- struct{}{}
 	}()
 	_, err := conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 	<-wait
 
 	// 2. update deadline
@@ -239,7 +272,7 @@ func TestConnReadDeadlineMultiConn(t *testing.T) {
 		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 	}()
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 	<-wait
 
 	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
@@ -253,7 +286,7 @@ func TestConnReadDeadlineMultiConn(t *testing.T) {
 		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
 	}()
 	_, err = conn.Read(data)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 	<-wait
 }
 
@@ -275,7 +308,7 @@ func TestConnWriteDeadlineMultiConn(t *testing.T) {
 		go func() {
 			for i := 0; i <= total; i++ {
 				if _, err := conn.Write(input); err != nil {
-					assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+					assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 					break
 				}
 			}
@@ -283,7 +316,7 @@ func TestConnWriteDeadlineMultiConn(t *testing.T) {
 		}()
 		for i := 0; i <= total; i++ {
 			if _, err := conn.Write(input); err != nil {
-				assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+				assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 				break
 			}
 		}
@@ -303,7 +336,7 @@ func TestConnWriteDeadlineMultiConn(t *testing.T) {
 			conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
 		}()
 		_, err := conn.Write(input)
-		assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 		<-wait
 	}
 
@@ -321,7 +354,7 @@ func TestConnWriteDeadlineMultiConn(t *testing.T) {
 			conn.SetWriteDeadline(time.Now().Add(1 * time.Second))
 		}()
 		_, err := conn.Write(input)
-		assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 		<-wait
 	}
 }
@@ -340,13 +373,13 @@ func TestConnWriteDeadline(t *testing.T) {
 	total := 1000000
 	for i := 0; i <= total; i++ {
 		if _, err := conn.Write(input); err != nil {
-			assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+			assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 			break
 		}
 	}
 
 	_, err := conn.Write(input)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// 2. update write deadline
 	conn.SetWriteDeadline(time.Time{})
@@ -355,23 +388,23 @@ func TestConnWriteDeadline(t *testing.T) {
 		conn.SetWriteDeadline(time.Now())
 	}()
 	_, err = conn.Write(input)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// 3. update dealine in pending io immediately
 	conn.SetWriteDeadline(time.Time{})
 	go conn.SetWriteDeadline(time.Now().Add(time.Second))
 	_, err = conn.Write(input)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	// 4. very short duration
 	conn.SetWriteDeadline(time.Now().Add(time.Millisecond))
 	_, err = conn.Write(input)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 
 	conn.SetWriteDeadline(time.Time{})
 	go conn.SetWriteDeadline(time.Now().Add(time.Millisecond))
 	_, err = conn.Write(input)
-	assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 }
 
 func TestConnWriteDeadlineException(t *testing.T) {
@@ -384,7 +417,7 @@ func TestConnWriteDeadlineException(t *testing.T) {
 		t.Log("initial less than now")
 		conn.SetWriteDeadline(time.Now().Add(-1 * time.Second))
 		_, err := conn.Write(input)
-		assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 	}
 
 	// 2. update less than now
@@ -398,7 +431,7 @@ func TestConnWriteDeadlineException(t *testing.T) {
 		total := 1000000
 		for i := 0; i <= total; i++ {
 			if _, err := conn.Write(input); err != nil {
-				assert.EqualError(t, err, os.ErrDeadlineExceeded.Error())
+				assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
 				break
 			}
 		}
@@ -410,20 +443,365 @@ func TestConnWriteDeadlineException(t *testing.T) {
 		client.Close()
 		conn.SetWriteDeadline(time.Time{})
 		n, err := conn.Write(input)
-		assert.EqualError(t, err, syscall.ECONNRESET.Error())
+		assert.ErrorIs(t, err, syscall.ECONNRESET)
 		assert.Equal(t, 0, n)
 	}
 
-	// 4. return EINVILA
+	// 4. return net.ErrClosed
 	{
-		t.Log("return EINVILA")
+		t.Log("return net.ErrClosed")
 		conn.Close()
 		n, err := conn.Write(input)
-		assert.EqualError(t, err, syscall.EINVAL.Error())
+		assert.ErrorIs(t, err, net.ErrClosed)
 		assert.Equal(t, 0, n)
 	}
 }
 
+func TestConnReadReset(t *testing.T) {
+	client := testDail(t)
+	conn := testNewConn(testAccept(t))
+	defer conn.Close()
+
+	tc, ok := client.(*net.TCPConn)
+	if !ok {
+		t.Skip("the client could not be reset")
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		tc.SetLinger(0) // send RST when closed
+		tc.Close()
+	}()
+
+	output := make([]byte, 1024)
+	_, err := conn.Read(output)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+
+	// the reset is kept, not reported as EOF or EPIPE.
+	_, err = conn.Read(output)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	_, err = conn.Write(output)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+}
+
+func TestConnWriteZC(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+
+	// the buffer is filled in place or by Write, then written as a whole.
+	data := bytes.Repeat([]byte("data_xxxx"), 4096)
+	b, err := conn.ZCBuffer(len(data))
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), b.Len())
+	if buf := b.Bytes(); buf != nil {
+		copy(buf, data)
+	} else {
+		_, err = b.Write(data)
+		assert.NoError(t, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := conn.WriteZC(b)
+		assert.NoError(t, err)
+		assert.Equal(t, len(data), n)
+	}()
+	output := make([]byte, len(data))
+	_, err = io.ReadFull(client, output)
+	assert.NoError(t, err)
+	assert.Equal(t, data, output)
+
+	// the buffer is freed by WriteZC.
+	<-done
+	assert.Nil(t, b.Bytes())
+	assert.Equal(t, 0, b.Len())
+
+	// the write buffer is not touched.
+	_, err = conn.Write([]byte("data_xxxx"))
+	assert.NoError(t, err)
+	_, err = io.ReadFull(client, output[:9])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data_xxxx"), output[:9])
+
+	assert.NoError(t, conn.Close())
+	_, err = conn.ZCBuffer(64)
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestConnReadView(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+	defer conn.Close()
+
+	_, err := client.Write([]byte("data_xxxx"))
+	assert.NoError(t, err)
+
+	// the view is not consumed until Release.
+	b, err := conn.Peek(4)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), b)
+	b, err = conn.ReadView()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data_xxxx"), b)
+	conn.Release(5)
+
+	output := make([]byte, 4)
+	n, err := conn.Read(output)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("xxxx"), output[:n])
+
+	// Peek appends to the buffered bytes until n bytes.
+	_, err = client.Write([]byte("0123"))
+	assert.NoError(t, err)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		client.Write([]byte("456789"))
+	}()
+	n, err = conn.Discard(3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	b, err = conn.Peek(7)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("3456789"), b)
+
+	_, err = conn.Peek(8193)
+	assert.ErrorIs(t, err, bufio.ErrBufferFull)
+
+	// the buffered bytes are returned with the error.
+	client.Close()
+	b, err = conn.Peek(10)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, []byte("3456789"), b)
+	n, err = conn.Discard(10)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 7, n)
+}
+
+func TestConnBuffers(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+
+	// the header and body are written in order, v is consumed.
+	header, body := []byte("data_xxxx"), bytes.Repeat([]byte("data_xxxx"), 4096)
+	v := net.Buffers{header, nil, body}
+	go func() {
+		n, err := conn.WriteBuffers(&v)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(header)+len(body)), n)
+		assert.Empty(t, v)
+	}()
+	output := make([]byte, len(header)+len(body))
+	_, err := io.ReadFull(client, output)
+	assert.NoError(t, err)
+	assert.Equal(t, header, output[:len(header)])
+	assert.Equal(t, body, output[len(header):])
+
+	// scatter the read into the buffers.
+	_, err = client.Write([]byte("data_xxxx"))
+	assert.NoError(t, err)
+	head, tail := make([]byte, 5), make([]byte, 8)
+	n, err := conn.ReadBuffers(net.Buffers{head, tail})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), n)
+	assert.Equal(t, []byte("data_"), head)
+	assert.Equal(t, []byte("xxxx"), tail[:4])
+
+	// the data buffered is read first.
+	_, err = client.Write([]byte("0123456789"))
+	assert.NoError(t, err)
+	_, err = conn.Peek(10)
+	assert.NoError(t, err)
+	n, err = conn.ReadBuffers(net.Buffers{head[:4], tail})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	assert.Equal(t, []byte("0123"), head[:4])
+	assert.Equal(t, []byte("456789"), tail[:6])
+
+	assert.NoError(t, conn.Close())
+	_, err = conn.WriteBuffers(&net.Buffers{header})
+	assert.ErrorIs(t, err, net.ErrClosed)
+	_, err = conn.ReadBuffers(net.Buffers{head})
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestConnReadFrom(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+	defer conn.Close()
+
+	// the reader is read into the write buffer directly.
+	data := bytes.Repeat([]byte("data_xxxx"), 4096)
+	go func() {
+		n, err := conn.ReadFrom(bytes.NewReader(data))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), n)
+	}()
+	output := make([]byte, len(data))
+	_, err := io.ReadFull(client, output)
+	assert.NoError(t, err)
+	assert.Equal(t, data, output)
+
+	// the read buffer is written to the writer directly until EOF.
+	_, err = client.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, testCloseWrite(client))
+	var w bytes.Buffer
+	n, err := conn.WriteTo(&w)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, w.Bytes())
+}
+
+func TestConnSplice(t *testing.T) {
+	client, peer := testDail(t), testDail(t)
+	defer client.Close()
+	defer peer.Close()
+	src := testNewConn(testAccept(t))
+	defer src.Close()
+	dst := testNewConn(testAccept(t))
+	defer dst.Close()
+
+	// io.Copy between usnet connections swaps the buffers.
+	data := bytes.Repeat([]byte("data_xxxx"), 4096)
+	buffers := []*buffer{src.rCtx.buffer, dst.wCtx.buffer}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n, err := io.Copy(dst, src)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), n)
+	}()
+
+	_, err := client.Write(data)
+	assert.NoError(t, err)
+	output := make([]byte, len(data))
+	_, err = io.ReadFull(peer, output)
+	assert.NoError(t, err)
+	assert.Equal(t, data, output)
+
+	assert.NoError(t, testCloseWrite(client))
+	<-done
+	assert.ElementsMatch(t, buffers, []*buffer{src.rCtx.buffer, dst.wCtx.buffer})
+}
+
+func TestConnAdaptive(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+	defer conn.Close()
+	pool := newSlabPool(defaultPoolLimit)
+	conn.setBuffers(pool, BufferPolicy{Min: 1024, Max: 4096, Idle: time.Hour}.normalize())
+
+	size := func(ctx *connCtx) int {
+		ctx.l.Lock()
+		defer ctx.l.Unlock()
+		return len(ctx.shadow)
+	}
+	assert.Equal(t, 1024, size(&conn.rCtx))
+	assert.Equal(t, 1024, size(&conn.wCtx))
+
+	// the read buffer doubles after a read fills it.
+	data := bytes.Repeat([]byte("data_xxxx"), 1000)
+	_, err := client.Write(data[:3000])
+	assert.NoError(t, err)
+	output := make([]byte, len(data))
+	_, err = io.ReadFull(conn, output[:3000])
+	assert.NoError(t, err)
+	assert.Equal(t, data[:3000], output[:3000])
+	assert.Equal(t, 2048, size(&conn.rCtx))
+
+	// the write buffer grows to the max.
+	go conn.Write(data[:5000])
+	_, err = io.ReadFull(client, output[:5000])
+	assert.NoError(t, err)
+	assert.Equal(t, data[:5000], output[:5000])
+	assert.Equal(t, 4096, size(&conn.wCtx))
+
+	// the idle buffers are put back by the second sweep without io between, the blocked
+	// read is interrupted and waits without its buffer.
+	done := make(chan []byte)
+	go func() {
+		b := make([]byte, 4)
+		n, _ := conn.Read(b)
+		done <- b[:n]
+	}()
+	assert.Eventually(t, func() bool {
+		conn.fd.irqHandler.RLock()
+		defer conn.fd.irqHandler.RUnlock()
+		return conn.fd.irqHandler.queues[queueOf(INT_SIG_INPUT)].Len() == 1
+	}, time.Second, time.Millisecond)
+	conn.sweep()
+	conn.sweep()
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&conn.polling) == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 1024+1024+2048+4096, pool.stats().Free)
+
+	// they are taken at the min again.
+	_, err = client.Write([]byte("data"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), <-done)
+	assert.Equal(t, 1024, size(&conn.rCtx))
+	_, err = conn.Write([]byte("ok"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1024, size(&conn.wCtx))
+}
+
+func TestConnSendQueue(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+	defer conn.Close()
+	conn.setSendQueue(&SendQueue{HighWater: 1 << 20, NonBlock: true}, nil)
+
+	// the small writes are queued and written in order.
+	for _, b := range []string{"data", "_", "xxxx"} {
+		n, err := conn.Write([]byte(b))
+		assert.NoError(t, err)
+		assert.Equal(t, len(b), n)
+	}
+	assert.NoError(t, conn.Flush())
+	assert.Empty(t, conn.sq.bufs) // put back once drained
+	output := make([]byte, 9)
+	_, err := io.ReadFull(client, output)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data_xxxx"), output)
+
+	// the queue stays full once the socket buffers are filled while the peer does not read.
+	chunk, full := bytes.Repeat([]byte("x"), 64<<10), 0
+	for i := 0; i < 4096 && full < 3; i++ {
+		if _, err = conn.Write(chunk); errors.Is(err, ErrQueueFull) {
+			full++
+			time.Sleep(5 * time.Millisecond)
+		} else {
+			full = 0
+		}
+	}
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	// the write deadline breaks the stalled queue without a writer waiting.
+	conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	assert.Eventually(t, func() bool {
+		conn.sq.l.Lock()
+		defer conn.sq.l.Unlock()
+		return errors.Is(conn.sq.err, os.ErrDeadlineExceeded)
+	}, time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, conn.Flush(), os.ErrDeadlineExceeded)
+	conn.SetWriteDeadline(time.Time{})
+	_, err = conn.Write([]byte("data"))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+// testCloseWrite: shut down the writing side of the net.Conn dialed by testDialer.
+func testCloseWrite(c net.Conn) error {
+	return c.(interface{ CloseWrite() error }).CloseWrite()
+}
+
 func testNewConn(fd int32) *conn {
 	return &conn{
 		fd: testNewDesc(fd),
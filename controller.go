@@ -0,0 +1,264 @@
+//go:build !syscall || !uring
+// +build !syscall !uring
+
+package usnet
+
+import (
+	"slices"
+	"unsafe"
+	"usnet/uscall"
+)
+
+// inflightIO: the io is done in the controller loop, nothing is left after the irq is claimed.
+const inflightIO = false
+
+// settle: the irq completed by others is not referred by the loop, nothing to wait.
+func (c *conn) settle(iReq *irq) error {
+	return iReq.err
+}
+
+// uscallController implement UscallController
+type uscallController struct {
+	p       *netpoller
+	irQueue *irqRing
+	loop    pollLoop
+	budget  Budget
+
+	pass    uint64
+	carried []*irq      // the irqs popped over the budget of their fd
+	stalled []stalledFd // the dispatches stopped by the budget
+
+	batch   *uscall.Batch // nil if the io is not batched
+	batched []*irq        // the irqs of the ops in batch
+
+	completions completer // the callbacks of the ops submitted
+}
+
+// batchHandler is implemented by the handlers whose io could be executed in batch.
+type batchHandler interface {
+	// batch: add the io into b, if it is not queued, the irq is handled and done is returned as Handle.
+	batch(b *uscall.Batch, iReq *irq) (queued, done bool)
+	// batched: the result of io, return true if the irq is done.
+	batched(iReq *irq, n int, err error) (done bool)
+}
+
+// stalledFd: the dispatch of sig on efd is continued in the next pass.
+type stalledFd struct {
+	efd *fdesc
+	sig INT_SIGNAL
+}
+
+func NewUscallController(p *netpoller) *uscallController {
+	c := &uscallController{
+		p:       p,
+		irQueue: newIrqRing(irqRingSize),
+	}
+	if p.evfd >= 0 {
+		c.irQueue.kick = p.wake
+	}
+	return c
+}
+
+// configure: apply the options of Listen.
+func (c *uscallController) configure(o *options) {
+	c.loop.policy, c.budget = o.poll, o.budget
+	if o.batch > 0 {
+		c.batch = uscall.NewBatch(o.batch)
+	}
+}
+
+// Serve: the irq is held by the controller until it is dropped.
+func (c *uscallController) Serve(iReq *irq) {
+	c.irQueue.push(iReq.hold())
+}
+
+func (c *uscallController) proc() {
+	uscall.UscallRun(func(p unsafe.Pointer) int32 {
+
+		if c.p.ref == 0 && !c.pending() {
+			c.loop.park()
+			c.irQueue.park()
+		}
+		c.pass++
+		active := len(c.carried) > 0 || len(c.stalled) > 0
+
+		// the work carried over first, the work deferred again is appended.
+		n := len(c.carried)
+		for _, iReq := range c.carried[:n] {
+			c.serve(iReq)
+		}
+		c.carried = slices.Delete(c.carried, 0, n)
+		c.flush()
+
+		n = len(c.stalled)
+		for _, s := range c.stalled[:n] {
+			c.dispatch(s.efd, s.sig)
+		}
+		c.stalled = slices.Delete(c.stalled, 0, n)
+
+		for n = 0; c.budget.Irqs <= 0 || n < c.budget.Irqs; n++ {
+			iReq := c.irQueue.pop()
+			if iReq == nil {
+				break
+			}
+			c.serve(iReq)
+			active = true
+		}
+		c.flush()
+
+		if c.p.ref == 0 {
+			c.loop.done(active)
+			return 0
+		}
+
+		// wait events, block with the timeout of policy if the loop is idle, the submission wakes it.
+		t := c.loop.timeout(c.pending())
+		if t > 0 && !c.irQueue.block() {
+			t = 0
+		}
+		events, err := c.p.wait(msec(t))
+		c.irQueue.unblock()
+		if err != nil {
+			return -1
+		}
+		for _, ev := range events {
+			c.handle(&ev)
+		}
+		c.loop.done(active || len(events) > 0)
+		return 0
+	}, nil)
+}
+
+// pending: whether any work is left for the next pass.
+func (c *uscallController) pending() bool {
+	return len(c.carried) > 0 || len(c.stalled) > 0 || !c.irQueue.empty()
+}
+
+// serve: handle the irq popped from irQueue, it is carried over if the budget of its fd is used up.
+func (c *uscallController) serve(iReq *irq) {
+	efd, _ := iReq.reg.(*fdesc)
+	if efd != nil && c.exhausted(efd) {
+		c.carried = append(c.carried, iReq)
+		c.loop.carried.Add(1)
+		return
+	}
+
+	if h, ok := iReq.ih.(batchHandler); ok && c.batch != nil {
+		if c.batch.Len() == c.batch.Cap() {
+			c.flush()
+		}
+		if queued, done := h.batch(c.batch, iReq); queued {
+			c.batched = append(c.batched, iReq)
+		} else {
+			c.finish(iReq, efd, done)
+		}
+		return
+	}
+
+	c.flush() // the io in batch goes first, the irq may close the fd.
+	c.finish(iReq, efd, iReq.ih.Handle(iReq))
+}
+
+// finish: the irq handled is saved to wait for the readiness, or released if it is done.
+func (c *uscallController) finish(iReq *irq, efd *fdesc, done bool) {
+	if !done {
+		iReq.reg.Save(iReq)
+		return
+	}
+	if efd != nil {
+		c.charge(efd, iReq.n)
+	}
+	iReq.release()
+}
+
+// flush: execute the io in batch by one cgo call, then complete the irqs in order.
+func (c *uscallController) flush() {
+	if c.batch == nil || c.batch.Len() == 0 {
+		return
+	}
+
+	uscall.UscallBatch(c.batch)
+	for k, iReq := range c.batched {
+		n, err := c.batch.Result(k)
+		efd, _ := iReq.reg.(*fdesc)
+		c.finish(iReq, efd, iReq.ih.(batchHandler).batched(iReq, n, err))
+	}
+	c.batched = slices.Delete(c.batched, 0, len(c.batched))
+	c.batch.Reset()
+}
+
+// exhausted: whether the bytes budget of efd is used up in this pass.
+func (c *uscallController) exhausted(efd *fdesc) bool {
+	return c.budget.Bytes > 0 && efd.pass == c.pass && efd.bytes >= c.budget.Bytes
+}
+
+// charge: account the io of efd in this pass.
+func (c *uscallController) charge(efd *fdesc, n int) {
+	if efd.pass != c.pass {
+		efd.pass, efd.bytes = c.pass, 0
+	}
+	efd.bytes += n
+}
+
+func (c *uscallController) handle(ev *uscall.Epoll_event) {
+	efd := c.p.getFd(ev)
+	if efd == nil {
+		return
+	}
+
+	if ev.Event()&(uscall.EPOLLRDHUP|uscall.EPOLLHUP) != 0 {
+		efd.hangup(ev.Event())
+	}
+
+	if efd.edge() { // save the readiness until EAGAIN
+		if ev.Event()&(uscall.EPOLLIN|uscall.EPOLLRDHUP|uscall.EPOLLHUP|uscall.EPOLLERR) != 0 {
+			efd.status.set(READABLE)
+		}
+		if ev.Event()&(uscall.EPOLLOUT|uscall.EPOLLHUP|uscall.EPOLLERR) != 0 {
+			efd.status.set(WRITEABLE)
+		}
+	}
+
+	// wake the waiters on hangup, they get io.EOF or ECONNRESET by read and write.
+	if ev.Event()&(uscall.EPOLLIN|uscall.EPOLLRDHUP|uscall.EPOLLHUP) != 0 {
+		c.dispatch(efd, INT_SIG_INPUT)
+	}
+
+	if ev.Event()&(uscall.EPOLLOUT|uscall.EPOLLHUP) != 0 {
+		c.dispatch(efd, INT_SIG_OUTPUT)
+	}
+
+	if ev.Event()&uscall.EPOLLERR != 0 {
+		// the error may be cleared by the read or write above.
+		err := uscall.UscallSockError(efd.fd)
+		if err == nil {
+			return
+		}
+		efd.broken(err)
+		efd.Range(func(i *irq) bool {
+			i.ih.Error(i, err)
+			efd.Remove(i)
+			i.release()
+			return true
+		})
+	}
+}
+
+// dispatch: handle the irqs of sig from the oldest one, stop at the first one
+// which is not done, the rest of irqs wait for the next readiness.
+// If the budget of efd is used up, the dispatch is continued in the next pass.
+func (c *uscallController) dispatch(efd *fdesc, sig INT_SIGNAL) {
+	for i := efd.Front(sig); i != nil; i = efd.Front(sig) {
+		if c.exhausted(efd) {
+			c.stalled = append(c.stalled, stalledFd{efd: efd, sig: sig})
+			c.loop.carried.Add(1)
+			return
+		}
+		if !i.ih.Handle(i) {
+			return
+		}
+		efd.Remove(i)
+		c.charge(efd, i.n)
+		i.release()
+	}
+}
@@ -0,0 +1,65 @@
+//go:build !syscall || !uring
+// +build !syscall !uring
+
+package usnet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testIoHandler: every irq is done with n bytes.
+type testIoHandler struct {
+	n, handled int
+}
+
+func (h *testIoHandler) Handle(iReq *irq) bool {
+	h.handled++
+	iReq.n = h.n
+	return true
+}
+
+func (h *testIoHandler) Error(iReq *irq, err error) {}
+
+func TestControllerBudget(t *testing.T) {
+	c := &uscallController{budget: Budget{Bytes: 10}}
+	efd := &fdesc{irqRegister: &irqRegister{}}
+	h := &testIoHandler{n: 8}
+
+	// the irqs over the bytes budget are carried over to the next pass.
+	c.pass++
+	for i := 0; i < 3; i++ {
+		c.serve(newIrq(h, efd, INT_SIG_INPUT))
+	}
+	assert.Equal(t, 2, h.handled)
+	assert.Len(t, c.carried, 1)
+
+	c.pass++
+	c.serve(c.carried[0])
+	assert.Equal(t, 3, h.handled)
+
+	// the dispatch is stopped and continued in the next pass.
+	h.n = 10
+	efd.Save(newIrq(h, efd, INT_SIG_INPUT))
+	efd.Save(newIrq(h, efd, INT_SIG_INPUT))
+	c.pass++
+	c.dispatch(efd, INT_SIG_INPUT)
+	assert.Equal(t, 4, h.handled)
+	assert.Equal(t, []stalledFd{{efd: efd, sig: INT_SIG_INPUT}}, c.stalled)
+	assert.NotNil(t, efd.Front(INT_SIG_INPUT))
+
+	c.pass++
+	c.dispatch(efd, INT_SIG_INPUT)
+	assert.Equal(t, 5, h.handled)
+	assert.Nil(t, efd.Front(INT_SIG_INPUT))
+
+	assert.Equal(t, uint64(2), c.loop.stats().Carried)
+
+	// unlimited
+	c.budget.Bytes = 0
+	for i := 0; i < 3; i++ {
+		c.serve(newIrq(h, efd, INT_SIG_INPUT))
+	}
+	assert.Equal(t, 8, h.handled)
+}
@@ -0,0 +1,438 @@
+//go:build syscall && uring
+// +build syscall,uring
+
+package usnet
+
+import (
+	"io"
+	"net"
+	"syscall"
+	"unsafe"
+	"usnet/uscall"
+)
+
+const uringEntries = 4096
+
+// uringTokenWake: the token of the poll sqe on the eventfd of netpoller, never taken by the irqs.
+const uringTokenWake = 1 << 62
+
+// inflightIO: the sqe may be in flight after the irq is completed by the timer, close or sweep,
+// the waiter cancels it and waits until it is reaped, see settle. So no sqe refers to the
+// buffers of connection once its ops are left, they are recycled as usual.
+const inflightIO = true
+
+/*
+uscallController implement UscallController on io_uring.
+
+	The irqs are submitted as sqes instead of waiting the readiness of fd,
+	the token of sqe maps the completion back to the pending irq.
+	If an operation returns EAGAIN, it is submitted again behind a linked poll sqe.
+*/
+type uscallController struct {
+	p       *netpoller
+	irQueue *irqRing
+	loop    pollLoop
+	budget  Budget
+
+	ring    *uscall.Uring
+	err     error // the error of setting up the ring
+	token   uint64
+	pending map[uint64]*irq
+	cqes    []uscall.UringCqe // sized as the event array of netpoller
+	armed   bool              // the eventfd is polled
+
+	completions completer // the callbacks of the ops submitted
+}
+
+// uringHandler is implemented by the handlers which could be served on io_uring.
+type uringHandler interface {
+	prepare(r *uscall.Uring, iReq *irq, token uint64) error
+	// complete: return true if the operation should be submitted again.
+	complete(iReq *irq, n int, err error) (again bool)
+}
+
+func NewUscallController(p *netpoller) *uscallController {
+	p.et = false // completed by cqe, no readiness to track
+	c := &uscallController{
+		p:       p,
+		irQueue: newIrqRing(irqRingSize),
+		pending: make(map[uint64]*irq),
+		cqes:    make([]uscall.UringCqe, len(p.events)),
+	}
+	c.ring, c.err = uscall.UscallUringSetup(uringEntries)
+	if p.evfd >= 0 {
+		c.irQueue.kick = p.wake
+	}
+	return c
+}
+
+// configure: apply the options of Listen, the batch is ignored.
+func (c *uscallController) configure(o *options) {
+	c.loop.policy, c.budget = o.poll, o.budget
+}
+
+// Serve: the irq is held by the controller until it is completed.
+func (c *uscallController) Serve(iReq *irq) {
+	c.irQueue.push(iReq.hold())
+}
+
+func (c *uscallController) proc() {
+	uscall.UscallRun(func(p unsafe.Pointer) int32 {
+
+		if len(c.pending) == 0 {
+			c.loop.park()
+			c.irQueue.park()
+		}
+		active := false
+		for n := 0; c.budget.Irqs <= 0 || n < c.budget.Irqs; n++ {
+			iReq := c.irQueue.pop()
+			if iReq == nil {
+				break
+			}
+			c.submit(iReq)
+			active = true
+		}
+
+		if len(c.pending) == 0 {
+			c.loop.done(active)
+			return 0
+		}
+
+		// submit sqes and reap cqes, block with the timeout of policy if the loop is idle.
+		t := c.loop.timeout(!c.irQueue.empty())
+		if _, err := c.ring.Submit(); err != nil && err != syscall.EAGAIN && err != syscall.EBUSY {
+			return -1
+		}
+		n := c.ring.Reap(c.cqes)
+		if n == 0 && t > 0 && c.irQueue.block() {
+			c.arm()
+			err := c.ring.Wait(t)
+			if c.irQueue.unblock(); err != nil && err != syscall.EAGAIN && err != syscall.EBUSY {
+				return -1
+			}
+			n = c.ring.Reap(c.cqes)
+		}
+		for i := 0; i < n; i++ {
+			c.complete(&c.cqes[i])
+		}
+		c.loop.done(active || n > 0)
+		return 0
+	}, nil)
+}
+
+func (c *uscallController) submit(iReq *irq) {
+	if h, ok := iReq.ih.(*cancelHandler); ok { // no cqe of the cancel is reaped
+		if token := h.target.token; token != 0 && c.ring.PrepCancel(token) == syscall.EBUSY {
+			c.ring.Submit()
+			c.ring.PrepCancel(token)
+		}
+		h.target.release()
+		iReq.release()
+		return
+	}
+
+	h, ok := iReq.ih.(uringHandler)
+	if !ok {
+		iReq.ih.Error(iReq, syscall.ENOTSUP)
+		iReq.release()
+		return
+	} else if c.err != nil {
+		h.complete(iReq, 0, c.err)
+		iReq.release()
+		return
+	}
+
+	c.token++
+	err := h.prepare(c.ring, iReq, c.token)
+	if err == syscall.EBUSY { // the submission ring is full, flush it and try again.
+		c.ring.Submit()
+		err = h.prepare(c.ring, iReq, c.token)
+	}
+
+	if err != nil {
+		h.complete(iReq, 0, err)
+		iReq.release()
+	} else {
+		c.pending[c.token] = iReq
+		iReq.token = c.token
+	}
+}
+
+// arm: poll the eventfd of netpoller, so the blocked wait is woken by the submission.
+func (c *uscallController) arm() {
+	if !c.armed && c.p.evfd >= 0 && c.ring.PrepPoll(c.p.evfd, uscall.EPOLLIN, uringTokenWake) == nil {
+		c.armed = true
+	}
+}
+
+func (c *uscallController) complete(cqe *uscall.UringCqe) {
+	if cqe.Token() == uringTokenWake {
+		uscall.UscallEventfdRead(c.p.evfd)
+		c.armed = false
+		return
+	}
+
+	iReq, ok := c.pending[cqe.Token()]
+	if !ok {
+		return
+	}
+	delete(c.pending, cqe.Token())
+	iReq.token = 0
+
+	n, err := cqe.Result()
+	if iReq.ih.(uringHandler).complete(iReq, n, err) {
+		iReq.retry++
+		c.submit(iReq)
+	} else {
+		iReq.release()
+	}
+}
+
+func (c *connHandler) prepare(r *uscall.Uring, iReq *irq, token uint64) error {
+	if iReq.state.Load() == IRQ_DONE { // completed while queued, nothing to submit
+		return syscall.ECANCELED
+	}
+
+	switch iReq.sig {
+	case INT_SIG_INPUT:
+		if err := c.fd.isOk('r'); err != nil {
+			return err
+		}
+		switch src := iReq.any.(type) {
+		case *uscall.IOVec:
+			return r.PrepRecvmsg(c.fd.fd, src, token, iReq.retry > 0)
+		case *Op: // the buffer of op is pinned by its iovec until it completes
+			src.iov.Reset()
+			src.iov.Add(src.b)
+			return r.PrepRecvmsg(c.fd.fd, src.iov, token, iReq.retry > 0)
+		case pollOnly: // the read buffer is released, poll the readiness only
+			return r.PrepPoll(c.fd.fd, uscall.EPOLLIN, token)
+		}
+		return r.PrepRecv(c.fd.fd, c.rCtx.CSpace(), token, iReq.retry > 0)
+	case INT_SIG_OUTPUT:
+		if err := c.fd.isOk('w'); err != nil {
+			return err
+		}
+		switch src := iReq.any.(type) {
+		case *sendQueue: // the head is not moved by the writers
+			src.l.Lock()
+			head := src.head()
+			src.l.Unlock()
+			return r.PrepSend(c.fd.fd, head.CData(), token, iReq.retry > 0)
+		case *uscall.ZCBuf:
+			return r.PrepSend(c.fd.fd, src.CData(), token, iReq.retry > 0)
+		case *uscall.IOVec:
+			return r.PrepSendmsg(c.fd.fd, src, token, iReq.retry > 0)
+		case *Op:
+			src.iov.Reset()
+			src.iov.Add(src.b[src.n:])
+			return r.PrepSendmsg(c.fd.fd, src.iov, token, iReq.retry > 0)
+		}
+		return r.PrepSend(c.fd.fd, c.wCtx.CData(), token, iReq.retry > 0)
+	default:
+		return syscall.EINVAL
+	}
+}
+
+func (c *connHandler) complete(iReq *irq, n int, err error) bool {
+	switch err {
+	case syscall.EAGAIN:
+		if iReq.state.Load() != IRQ_DONE {
+			return true
+		}
+	case syscall.ECANCELED: // cancelled by close, report it as the closed fd does.
+		err = net.ErrClosed
+	case nil: // the send queue and the write op are written until done
+		var again bool
+		switch src := iReq.any.(type) {
+		case *sendQueue:
+			again = n > 0 && src.sent(n)
+		case *Op:
+			again = src.mode == 'w' && n > 0 && src.transferred(n)
+		}
+		if again && iReq.state.Load() != IRQ_DONE {
+			return true
+		}
+	}
+
+	// the sqe in flight is pending, it may be completed by the timer, close or sweep.
+	if !iReq.claim() {
+		c.orphan(iReq, n, err)
+		return false
+	}
+
+	if _, ok := iReq.any.(pollOnly); ok { // the events polled, nothing is read
+		n, err = 0, c.fd.broken(err)
+	} else if iReq.sig == INT_SIG_INPUT {
+		err = c.fd.eofError(n, err)
+	} else if zc, ok := iReq.any.(*uscall.ZCBuf); ok && err == nil && n > 0 {
+		zc.Consume(n)
+	} else if err == nil && n == 0 {
+		err = io.ErrUnexpectedEOF
+	} else {
+		err = c.fd.broken(err)
+	}
+
+	iReq.n, iReq.err = n, err
+	iReq.reaped.Store(true)
+	c.fd.interrupt(INT_SRC_POLLER, doneMf(iReq), false)
+	return false
+}
+
+// orphan: the sqe of the irq completed by others is reaped. The reset is saved, or the next io
+// gets EPIPE. The bytes transferred are not lost: the read returns them instead of the error,
+// the write counts them. Then the waiter settling the irq is woken, or the submitter notified.
+func (c *connHandler) orphan(iReq *irq, n int, err error) {
+	if _, ok := iReq.any.(pollOnly); ok {
+		n, err = 0, c.fd.broken(err)
+	} else if iReq.sig == INT_SIG_INPUT {
+		err = c.fd.eofError(n, err)
+	} else {
+		err = c.fd.broken(err)
+	}
+
+	c.fd.irqHandler.Lock()
+	defer c.fd.irqHandler.Unlock()
+
+	if err == nil && n > 0 {
+		iReq.n = n
+		if iReq.sig == INT_SIG_INPUT {
+			iReq.err = nil
+		}
+	}
+	iReq.reaped.Store(true)
+	if iReq.notify != nil {
+		iReq.notify(iReq)
+	} else {
+		iReq.signal()
+	}
+}
+
+// settle: the irq is completed by the timer, close or sweep while its sqe may be in flight,
+// cancel the sqe and wait until it is reaped, so the buffer is not reused meanwhile.
+func (c *conn) settle(iReq *irq) error {
+	(*connHandler)(c).cancel(iReq)
+	for !iReq.reaped.Load() {
+		<-iReq.wake
+	}
+	return iReq.err
+}
+
+// Cancel: implement UscallCanceller, the submitted irq is notified by orphan if it is not reaped.
+func (c *connHandler) Cancel(iReq *irq) bool {
+	if iReq.reaped.Load() {
+		return false
+	}
+	c.cancel(iReq)
+	return true
+}
+
+// cancel: cancel the sqe of the irq completed by others, the cqe is reaped as usual.
+// The send queue is broken at once, its buffers are dropped once the sqe is reaped.
+func (c *connHandler) cancel(iReq *irq) {
+	if q, ok := iReq.any.(*sendQueue); ok {
+		q.abort(iReq.err)
+	}
+	cReq := newIrq(&cancelHandler{target: iReq.hold()}, nil, INT_SIG_EXP)
+	c.utrl.Serve(cReq)
+	cReq.release()
+}
+
+// cancelHandler cancel the sqe of target in flight, it is served by the controller directly.
+type cancelHandler struct {
+	target *irq
+}
+
+func (h *cancelHandler) Handle(iReq *irq) bool { return true }
+
+func (h *cancelHandler) Error(iReq *irq, err error) {}
+
+func (a *acceptHandler) prepare(r *uscall.Uring, iReq *irq, token uint64) error {
+	if err := a.lisfd.isOk('r'); err != nil {
+		return err
+	}
+	return r.PrepAccept(a.lisfd.fd, token, iReq.retry > 0)
+}
+
+func (a *acceptHandler) complete(iReq *irq, fd int, err error) bool {
+	if err == syscall.EAGAIN {
+		return true
+	} else if !iReq.claim() { // completed by close
+		if err == nil {
+			uscall.UscallClose(int32(fd))
+		}
+		return false
+	}
+
+	switch err {
+	case nil:
+		c := (*TCPListener)(a).create(int32(fd), nil)
+		hReq := newIrq(&hangupHandler{fd: c.fd}, c.fd, INT_SIG_EXP)
+		a.utrl.Serve(hReq)
+		hReq.release()
+		iReq.any = c
+	case syscall.ECANCELED:
+		iReq.err = net.ErrClosed
+	default:
+		iReq.err = err
+	}
+
+	a.lisfd.interrupt(INT_SRC_POLLER, doneMf(iReq), false)
+	return false
+}
+
+// hangupHandler watch the hangup of the idle connection, it is cancelled by close.
+type hangupHandler struct {
+	fd *fdesc
+}
+
+func (h *hangupHandler) Handle(iReq *irq) bool { return true }
+
+func (h *hangupHandler) Error(iReq *irq, err error) {}
+
+func (h *hangupHandler) prepare(r *uscall.Uring, iReq *irq, token uint64) error {
+	if err := h.fd.isOk('r'); err != nil {
+		return err
+	}
+	return r.PrepPoll(h.fd.fd, uscall.EPOLLRDHUP, token)
+}
+
+func (h *hangupHandler) complete(iReq *irq, events int, err error) bool {
+	if err != nil {
+		return false
+	} else if uint32(events)&uscall.EPOLLERR != 0 { // saved as the error of read and write
+		if err = uscall.UscallSockError(h.fd.fd); err != nil {
+			h.fd.broken(err)
+		}
+	}
+	if uint32(events)&(uscall.EPOLLRDHUP|uscall.EPOLLHUP) != 0 {
+		h.fd.hangup(uint32(events))
+	}
+	return false
+}
+
+func (ch *closeHandler) prepare(r *uscall.Uring, iReq *irq, token uint64) error {
+	if ch.fd.status.has(CLOSED) {
+		return net.ErrClosed
+	}
+
+	if err := r.PrepClose(ch.fd.fd, token); err != nil {
+		return err
+	}
+	ch.fd.status.set(CLOSED) // refuse the following irqs while closing.
+	return nil
+}
+
+func (ch *closeHandler) complete(iReq *irq, n int, err error) bool {
+	if err == syscall.EBUSY { // the close sqe has not been submitted.
+		_, err = uscall.UscallClose(ch.fd.fd)
+	}
+
+	iReq.err = err
+	ch.fd.release()
+	if ch.poller != nil {
+		ch.poller.close()
+	}
+	ch.fd.interrupt(INT_SRC_POLLER, closedMf(iReq), true)
+	return false
+}
@@ -2,6 +2,7 @@ package usnet
 
 import (
 	"io"
+	"net"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -22,14 +23,16 @@ type fdlCtx struct {
 	deadline time.Time // update in UpdateDeadline
 	// when deadline execeeded, lazy modify in DealineExceeded
 	// reset in UpdateDeadline
-	dead   bool
-	dlSeq  int64
-	dlFunc func() // update in UpdateDeadline
+	dead  bool
+	dlSeq int64
+	job   deadlineJob // update in UpdateDeadline
 
 	dlTimer timer
-	// Task is must nil when the deadline is zero.
-	// and  is lazy inited in UpdateDeadline and fd.prepare when the fd is refered.
+	// Task is unbound when the deadline is zero,
+	// and is lazy bound in UpdateDeadline and fd.prepare when the fd is refered.
+	// The task is kept after unbound, it is added again by update.
 	dlTask task
+	bound  bool
 }
 
 func (fc *fdlCtx) Close() {
@@ -46,7 +49,8 @@ func (fc *fdlCtx) UpdateDeadline(d time.Time, fd *fdesc, mode int) {
 		return
 	}
 
-	fc.dead, fc.deadline, fc.dlFunc = false, d, fc.deadlineFunc(fd, mode)
+	fc.dead, fc.deadline = false, d
+	fc.reset(fd, mode)
 
 	bind := !d.IsZero()
 	if mode == 'r' {
@@ -62,47 +66,71 @@ func (fc *fdlCtx) UpdateDeadline(d time.Time, fd *fdesc, mode int) {
 	}
 }
 
-func (fc *fdlCtx) deadlineFunc(fd *fdesc, mode int) func() {
+// reset: bind the job to fd and clean the exceeded status of the last deadline.
+func (fc *fdlCtx) reset(fd *fdesc, mode int) {
 	fd.irqHandler.RLock()
 	defer fd.irqHandler.RUnlock()
 
-	seq := atomic.AddInt64(&fc.dlSeq, 1)
-	if mode == 'r' {
-		fd.checkStatus(RDL_EXECEEDE, true) // clean dealine status
-		return func() {
-			m := errorWrapMf(equalMf(INT_SIG_INPUT, nil),
-				os.ErrDeadlineExceeded)
-			fd.irqHandler.interrupt(INT_SRC_TIMER, m, true, func() {
-				if atomic.CompareAndSwapInt64(&fc.dlSeq, seq, seq+1) {
-					fd.status |= RDL_EXECEEDE
-				}
-			})
-		}
-	} else {
-		fd.checkStatus(WDL_EXECEEDE, true) // clean dealine status
-		return func() {
-			m := errorWrapMf(equalMf(INT_SIG_OUTPUT, nil),
-				os.ErrDeadlineExceeded)
-			fd.irqHandler.interrupt(INT_SRC_TIMER, m, true, func() {
-				if atomic.CompareAndSwapInt64(&fc.dlSeq, seq, seq+1) {
-					fd.status |= WDL_EXECEEDE
-				}
-			})
-		}
+	if fc.job.fc == nil {
+		fc.job.fc, fc.job.fd, fc.job.mode = fc, fd, mode
 	}
+	fc.job.seq = atomic.AddInt64(&fc.dlSeq, 1)
+	_, status := fc.job.signal()
+	fd.checkStatus(status, true) // clean dealine status
+}
+
+// deadlineJob: the timer job of fdlCtx, it is reused by every deadline update.
+type deadlineJob struct {
+	fc   *fdlCtx
+	fd   *fdesc
+	mode int
+	seq  int64 // the dlSeq of deadline, guarded by fc.l
+}
+
+// signal: the interrupt signal and the exceeded status of the mode.
+func (j *deadlineJob) signal() (INT_SIGNAL, FD_STATUS) {
+	if j.mode == 'r' {
+		return INT_SIG_INPUT, RDL_EXECEEDE
+	}
+	return INT_SIG_OUTPUT, WDL_EXECEEDE
+}
+
+// time: it is read by the timer in add and update, fc.l is held by the caller.
+func (j *deadlineJob) time() time.Time {
+	return j.fc.deadline
+}
+
+func (j *deadlineJob) run() {
+	fc, fd := j.fc, j.fd
+	fc.l.RLock()
+	seq, deadline := j.seq, fc.deadline
+	fc.l.RUnlock()
+
+	// the deadline has been updated after the job popped.
+	if deadline.IsZero() || deadline.After(time.Now()) {
+		return
+	}
+
+	sig, status := j.signal()
+	m := errorWrapMf(claimMf(sig), os.ErrDeadlineExceeded)
+	fd.irqHandler.interrupt(INT_SRC_TIMER, m, true, func() {
+		if atomic.CompareAndSwapInt64(&fc.dlSeq, seq, seq+1) {
+			fd.status.set(status)
+		}
+	})
 }
 
 // DealineExceeded: return if the deadline has been exceeded
 // and the timer  is enable.
 func (fc *fdlCtx) DealineExceeded() (dead bool, enable bool) {
-	fc.l.RLock()
-	defer fc.l.RUnlock()
+	fc.l.Lock() // dead is modified
+	defer fc.l.Unlock()
 
 	// check if deadline exceeded?
 	if fc.dead || (!fc.deadline.IsZero() && !fc.deadline.After(time.Now())) {
 		fc.dead = true
 	}
-	return fc.dead, fc.deadline.IsZero() || fc.dlTask != nil
+	return fc.dead, fc.deadline.IsZero() || fc.bound
 }
 
 func (fc *fdlCtx) BindTimer() {
@@ -114,12 +142,12 @@ func (fc *fdlCtx) BindTimer() {
 
 func (fc *fdlCtx) bindTimer(force bool) {
 	if fc.dlTimer != nil {
-		j := &jobImpl{deadline: fc.deadline, proc: fc.dlFunc}
 		if fc.dlTask == nil {
-			fc.dlTask = fc.dlTimer.add(j)
-		} else if force {
-			fc.dlTimer.update(fc.dlTask, j)
+			fc.dlTask = fc.dlTimer.add(&fc.job)
+		} else if force || !fc.bound {
+			fc.dlTimer.update(fc.dlTask, &fc.job)
 		}
+		fc.bound = true
 	}
 }
 
@@ -131,9 +159,9 @@ func (c *fdlCtx) UnbindTimer() {
 }
 
 func (fc *fdlCtx) unbindTimer() {
-	if fc.dlTask != nil && fc.dlTimer != nil {
+	if fc.bound && fc.dlTimer != nil {
 		fc.dlTimer.remove(fc.dlTask)
-		fc.dlTask = nil
+		fc.bound = false
 	}
 }
 
@@ -149,14 +177,44 @@ const (
 	CLOSED
 	RDL_EXECEEDE
 	WDL_EXECEEDE
+	RDHUP // the peer has shutdown writing or closed
+	HUP   // the connection has been hung up or reset
 )
 
-// Note: fdesc is not concurrency safe.
+// fdStatus is the atomic bitset of FD_STATUS.
+type fdStatus struct {
+	v atomic.Int64
+}
+
+func (s *fdStatus) set(st FD_STATUS) {
+	s.v.Or(st)
+}
+
+// clear: clean the bits, return the bits which were set.
+func (s *fdStatus) clear(st FD_STATUS) FD_STATUS {
+	return s.v.And(^st) & st
+}
+
+func (s *fdStatus) has(st FD_STATUS) bool {
+	return s.v.Load()&st != 0
+}
+
+/*
+fdesc is the file description shared by the controller thread, the timer and the user goroutines.
+
+	The status is an atomic bitset, the bits are owned by:
+	  READABLE, WRITEABLE, RDHUP, HUP, ERROR, CLOSED: set by the controller thread,
+	    READABLE and WRITEABLE are cleaned by it too, the others are never cleaned;
+	  RDL_EXECEEDE, WDL_EXECEEDE: set by the timer, cleaned by the goroutine updating deadline.
+	The err is written before ERROR is set, so it could be read without lock once ERROR is seen.
+	The ev is protected by the irqHandler locker.
+*/
 type fdesc struct {
 	fd int32
 	*irqHandler
 
-	status FD_STATUS
+	status fdStatus
+	err    error // the socket error saved with ERROR status
 
 	rref, wref     int64
 	rwaits, wwaits int64
@@ -165,6 +223,9 @@ type fdesc struct {
 
 	poller *netpoller
 	ev     *uscall.Epoll_event
+	pass   uint64        // the pass of controller loop charged, owned by the controller thread
+	bytes  int           // the bytes read and written in the pass
+	token  atomic.Uint64 // the token in netpoller, zero if not added.
 
 	*irqRegister
 }
@@ -189,7 +250,68 @@ func (fd *fdesc) decref(mode int) {
 	}
 }
 
+// refs: the count of ops running on the fd.
+func (fd *fdesc) refs() int64 {
+	return atomic.LoadInt64(&fd.rref) + atomic.LoadInt64(&fd.wref)
+}
+
+// edge: the fd is registered once in edge-triggered mode, the events are not added or deleted by waiters.
+func (fd *fdesc) edge() bool {
+	return fd.poller != nil && fd.poller.et
+}
+
+// netpoller_register: register the fd once the socket created, the fd is assumed ready
+// for the status until EAGAIN in edge-triggered mode.
+func (fd *fdesc) netpoller_register(event uint32, status FD_STATUS) error {
+	if fd.edge() {
+		event |= uscall.EPOLLET
+	}
+	ev := (&uscall.Epoll_event{}).SetEvents(event | uscall.EPOLLERR).
+		SetSocket(fd.fd)
+	if err := fd.poller.ctl_add(fd, ev); err != nil {
+		return err
+	}
+	fd.ev = ev
+	fd.status.set(status)
+	return nil
+}
+
+// ready: if the fd may be ready for the status, always true in level-triggered mode.
+func (fd *fdesc) ready(status FD_STATUS) bool {
+	return !fd.edge() || fd.status.has(status)
+}
+
+// drained: clean the readiness after EAGAIN in edge-triggered mode, wait for the next edge.
+func (fd *fdesc) drained(status FD_STATUS) {
+	if fd.edge() {
+		fd.status.clear(status)
+	}
+}
+
+// hangup: save the hangup of peer, EPOLLRDHUP is not watched any more in level-triggered mode,
+// or it will be reported until the fd closed.
+func (fd *fdesc) hangup(event uint32) {
+	if event&uscall.EPOLLHUP != 0 {
+		fd.status.set(HUP | RDHUP)
+	} else {
+		fd.status.set(RDHUP)
+	}
+
+	if !fd.edge() {
+		fd.irqHandler.Lock()
+		defer fd.irqHandler.Unlock()
+
+		if fd.ev != nil && fd.ev.Event()&uscall.EPOLLRDHUP != 0 {
+			fd.ev.SetEvents(fd.ev.Event() & ^uscall.EPOLLRDHUP)
+			fd.poller.ctl_modify(fd, fd.ev)
+		}
+	}
+}
+
 func (fd *fdesc) netpoller_add_event(ref *int64, event uint32) error {
+	if fd.edge() {
+		return nil
+	}
 	if atomic.AddInt64(ref, 1)-1 == 0 {
 		fd.irqHandler.Lock()
 		defer fd.irqHandler.Unlock()
@@ -214,6 +336,9 @@ func (fd *fdesc) netpoller_add_event(ref *int64, event uint32) error {
 }
 
 func (fd *fdesc) netpoller_delete_event(ref *int64, event uint32) error {
+	if fd.edge() {
+		return nil
+	}
 	if atomic.AddInt64(ref, -1) == 0 {
 		fd.irqHandler.Lock()
 		defer fd.irqHandler.Unlock()
@@ -223,7 +348,7 @@ func (fd *fdesc) netpoller_delete_event(ref *int64, event uint32) error {
 		}
 
 		// if nev := fd.ev.Event() & ^event; nev == uscall.EPOLLERR {
-		if nev := fd.ev.Event() & ^event; fd.status&CLOSED != 0 {
+		if nev := fd.ev.Event() & ^event; fd.status.has(CLOSED) {
 			fd.poller.ctl_delete(fd, fd.ev)
 			fd.ev = nil
 		} else {
@@ -238,24 +363,65 @@ func (fd *fdesc) netpoller_delete_event(ref *int64, event uint32) error {
 	return nil
 }
 
+// fail: save the socket error, the following io returns it.
+func (fd *fdesc) fail(err error) {
+	fd.irqHandler.Lock()
+	defer fd.irqHandler.Unlock()
+
+	if !fd.status.has(ERROR) {
+		fd.err = err
+		fd.status.set(ERROR) // publish the err
+	}
+}
+
+// submit: serve the irq without listening, notify is called with the lock of irqHandler when it
+// is done, it must be fast and release the irq. The irq fails at once if fd is not ok for mode.
+func (fd *fdesc) submit(iReq *irq, mode int, notify func(*irq), utrl UscallController) {
+	iReq.notify = notify
+	fd.trap(iReq)
+
+	if err := fd.isOk(mode); err != nil && iReq.claim() {
+		iReq.err = err
+		iReq.reaped.Store(true) // never served
+		fd.irqHandler.wake(INT_SRC_NONE, iReq)
+		fd.irqHandler.Unlock()
+		return
+	}
+
+	// held until served, the notify may release it once unlocked, see serve.
+	iReq.hold()
+	fd.irqHandler.Unlock()
+	utrl.Serve(iReq)
+	iReq.release()
+}
+
+// serve: hand the trapped irq to the controller outside the lock of fd, which is taken by the
+// controller to complete the irqs. It is called with the lock and returns with it.
+func (fd *fdesc) serve(iReq *irq, utrl UscallController) {
+	fd.irqHandler.Unlock()
+	utrl.Serve(iReq)
+	fd.irqHandler.Lock()
+}
+
 func (fd *fdesc) isOk(mode int) error {
-	if fd.status&(ERROR|CLOSED) != 0 {
-		return syscall.EINVAL
+	if fd.status.has(CLOSED) {
+		return net.ErrClosed
+	} else if fd.status.has(ERROR) {
+		return fd.err
 	}
-	if mode == 'r' && fd.status&RDL_EXECEEDE != 0 {
+	if mode == 'r' && fd.status.has(RDL_EXECEEDE) {
 		return os.ErrDeadlineExceeded
-	} else if mode == 'w' && fd.status&WDL_EXECEEDE != 0 {
+	} else if mode == 'w' && fd.status.has(WDL_EXECEEDE) {
 		return os.ErrDeadlineExceeded
 	}
 	return nil
 }
 
 func (fd *fdesc) checkStatus(notice FD_STATUS, autoClean bool) bool {
-	st := fd.status & notice
 	if autoClean {
-		fd.status &= ^st
+		return fd.status.clear(notice) != 0
 	}
-	return st != 0
+	return fd.status.has(notice)
 }
 
 func (fd *fdesc) suspend(mode int) error {
@@ -298,16 +464,44 @@ func (fd *fdesc) suspend(mode int) error {
 	}
 }
 
+// eofError: return io.EOF when read nothing, and save the hangup of peer.
 func (fd *fdesc) eofError(n int, err error) error {
 	if n == 0 && err == nil {
+		fd.status.set(RDHUP)
 		return io.EOF
 	}
+	return fd.broken(err)
+}
+
+// broken: save the socket error which breaks the connection, so a reset, timeout or
+// unreachable peer is not reported as EOF or EPIPE by the following io.
+func (fd *fdesc) broken(err error) error {
+	if errno, ok := err.(syscall.Errno); ok && fatalErrno(errno) {
+		fd.status.set(HUP | RDHUP)
+		fd.fail(err)
+	}
 	return err
 }
 
+// fatalErrno: the errno is not retryable, nor the local misuse. EPIPE is the local shutdown
+// of writing, the reads go on.
+func fatalErrno(errno syscall.Errno) bool {
+	switch errno {
+	case syscall.EAGAIN, syscall.EINTR, syscall.EINPROGRESS, syscall.EALREADY, syscall.EBUSY,
+		syscall.ENOBUFS, syscall.ENOMEM, syscall.ECANCELED, syscall.EPIPE,
+		syscall.EBADF, syscall.EINVAL, syscall.EFAULT, syscall.EMSGSIZE, syscall.ENOTSUP:
+		return false
+	}
+	return true
+}
+
 // read: return read length [0, ~)， error
 func (fd *fdesc) read(cs *uscall.CSlice) (int, error) {
-	nread, err := uscall.UscallReadCSlice(fd.fd, cs)
+	return fd.readResult(uscall.UscallReadCSlice(fd.fd, cs))
+}
+
+// readResult: convert the result of read call, it is shared by the batched read.
+func (fd *fdesc) readResult(nread int, err error) (int, error) {
 	if err != nil {
 		nread = 0 // Note: set zero
 	}
@@ -316,29 +510,42 @@ func (fd *fdesc) read(cs *uscall.CSlice) (int, error) {
 
 // read: write written length [0, ~)， error
 func (fd *fdesc) write(cs *uscall.CSlice) (nwrite int, err error) {
-	if nwrite, err = uscall.UscallWriteCSlice(fd.fd, cs); err != nil {
-		nwrite = 0
+	return fd.writeResult(uscall.UscallWriteCSlice(fd.fd, cs))
+}
+
+// writeResult: convert the result of write call, it is shared by the batched write.
+func (fd *fdesc) writeResult(nwrite int, err error) (int, error) {
+	if err != nil {
+		nwrite, err = 0, fd.broken(err)
 	} else if nwrite == 0 {
 		err = io.ErrUnexpectedEOF
 	}
-	return
+	return nwrite, err
 }
 
 func (fd *fdesc) close() (err error) {
-	if fd.status&CLOSED == 0 {
+	if !fd.status.has(CLOSED) {
+		fd.irqHandler.Lock()
 		if fd.ev != nil {
 			fd.poller.ctl_delete(fd, fd.ev)
 			fd.ev = nil
 		}
+		fd.irqHandler.Unlock()
 		_, err = uscall.UscallClose(fd.fd)
-		fd.status |= CLOSED
-
-		fd.rdCtx.Close()
-		fd.wdCtx.Close()
+		fd.release()
 	}
 	return err
 }
 
+// release: mark the fd closed and stop the deadline timers,
+// the fd itself must have been closed.
+func (fd *fdesc) release() {
+	fd.status.set(CLOSED)
+
+	fd.rdCtx.Close()
+	fd.wdCtx.Close()
+}
+
 func (fd *fdesc) setReadDeadline(d time.Time) {
 	fd.rdCtx.UpdateDeadline(d, fd, 'r')
 }
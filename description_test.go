@@ -2,8 +2,10 @@ package usnet
 
 import (
 	"fmt"
+	"io"
 	"net"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 	"usnet/uscall"
@@ -124,6 +126,25 @@ func TestDescEcho(t *testing.T) {
 	}
 }
 
+func TestDescBroken(t *testing.T) {
+	// the retryable errors and the local shutdown are not saved.
+	fd := &fdesc{irqHandler: newIrqHandler()}
+	for _, err := range []error{syscall.EAGAIN, syscall.EPIPE, syscall.ECANCELED, io.EOF} {
+		assert.Equal(t, err, fd.broken(err))
+		assert.NoError(t, fd.isOk('r'))
+	}
+
+	// the socket errors break the connection, the first one is kept.
+	for _, err := range []error{syscall.EHOSTUNREACH, syscall.ENETUNREACH, syscall.ECONNREFUSED} {
+		fd := &fdesc{irqHandler: newIrqHandler()}
+		assert.Equal(t, err, fd.broken(err))
+		fd.broken(syscall.ECONNRESET)
+		assert.Equal(t, err, fd.isOk('r'))
+		assert.Equal(t, err, fd.isOk('w'))
+		assert.True(t, fd.status.has(HUP|RDHUP))
+	}
+}
+
 func testNewDesc(fd int32) *fdesc {
 	testDescInit()
 	return &fdesc{
@@ -143,7 +164,7 @@ func testNewDesc(fd int32) *fdesc {
 func testDescInit() {
 	once.Do(func() {
 		var err error
-		poller, err = createNetPoller()
+		poller, err = createNetPoller(0)
 		if err != nil {
 			panic(err)
 		}
@@ -189,7 +210,7 @@ func testCBytes(data []byte) []byte {
 func testDail(t *testing.T) net.Conn {
 	testDescInit()
 
-	client, err := net.Dial("tcp", fmt.Sprintf("%s:%d", addr, port))
+	client, err := testDialer("tcp", fmt.Sprintf("%s:%d", addr, port))
 	assert.NoError(t, err, "connect failure.")
 	return client
 }
@@ -209,4 +230,6 @@ var (
 	addr        = "127.0.0.1"
 	sockfd int32
 	ttimer = NewTimer()
+
+	testDialer = net.Dial
 )
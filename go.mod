@@ -1,16 +1,17 @@
 module usnet
 
-go 1.19
+go 1.23.1
 
 require (
-	github.com/redresseur/utils v0.0.0-20220921085138-02c04b06de48
 	github.com/stretchr/testify v1.8.1
+	gvisor.dev/gvisor v0.0.0-20250503011706-39ed1f5ac29c
 )
 
-replace github.com/redresseur/utils v0.0.0-20220921085138-02c04b06de48 => /home/wzp/workspace/utils
-
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/google/btree v1.1.2 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.26.0 // indirect
+	golang.org/x/time v0.7.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,8 +1,8 @@
 package usnet
 
 import (
-	"container/list"
 	"sync"
+	"sync/atomic"
 )
 
 type INT_SOURCE int
@@ -20,20 +20,139 @@ const (
 	INT_SIG_OUTPUT
 	INT_SIG_TIMEOUT
 	INT_SIG_EXP
+
+	INT_SIG_ANY INT_SIGNAL = -1 // match the irqs of all signals
+)
+
+// the irqs are queued by signal: input, output and the others.
+const irqQueues = 3
+
+const (
+	linkHandler  = iota // the link in irqHandler
+	linkRegister        // the link in irqRegister
+	irqLinks
+)
+
+// irqLink: the intrusive link of irq, queueing an irq allocates nothing.
+type irqLink struct {
+	prev, next *irq
+	queued     bool
+}
+
+// irqQueue: the intrusive FIFO queue of irqs, k is the link used.
+type irqQueue struct {
+	front, back *irq
+	n           int
+}
+
+func (q *irqQueue) Len() int {
+	return q.n
+}
+
+func (q *irqQueue) push(i *irq, k int) {
+	l := &i.links[k]
+	l.prev, l.next, l.queued = q.back, nil, true
+	if q.back != nil {
+		q.back.links[k].next = i
+	} else {
+		q.front = i
+	}
+	q.back = i
+	q.n++
+}
+
+func (q *irqQueue) remove(i *irq, k int) {
+	l := &i.links[k]
+	if l.prev != nil {
+		l.prev.links[k].next = l.next
+	} else {
+		q.front = l.next
+	}
+	if l.next != nil {
+		l.next.links[k].prev = l.prev
+	} else {
+		q.back = l.prev
+	}
+	*l = irqLink{}
+	q.n--
+}
+
+func queueOf(sig INT_SIGNAL) int {
+	switch sig {
+	case INT_SIG_INPUT:
+		return 0
+	case INT_SIG_OUTPUT:
+		return 1
+	default:
+		return 2
+	}
+}
+
+const (
+	IRQ_PENDING int32 = iota // waiting, could be claimed by anyone
+	IRQ_RUNNING              // the io is running on the controller thread
+	IRQ_DONE                 // the result has been written
 )
 
 // interrupt request
 type irq struct {
-	src INT_SOURCE // the source of  interrupt signal
-	sig INT_SIGNAL // the
-	seq int64
-	le  *list.Element
+	src   INT_SOURCE // the source of  interrupt signal
+	sig   INT_SIGNAL // the
+	seq   int64
+	links [irqLinks]irqLink // linked in irqHandler and irqRegister
+	state atomic.Int32      // only the owner changing the state writes the result
+	wake  chan struct{}     // one-shot, signaled when the irq is interrupted
 
 	retry int
-	err   error // happend error
+	refs  atomic.Int32 // the waiter and the controller, put back to pool by the last one
+	n     int          // the length of io, not boxed into any
+	err   error        // happend error
 	any   interface{}
 	ih    UscallHandler
 	reg   UscallRegister
+
+	notify func(*irq) // called instead of waking the listener, the irq submitted has no listener
+
+	// io_uring: the sqe of irq may be in flight after it is completed by others.
+	token  uint64      // the token of the sqe in flight, zero if none, owned by the controller
+	reaped atomic.Bool // the controller is done with the irq, no sqe refers to its memory
+}
+
+var irqPool = sync.Pool{
+	New: func() any {
+		return &irq{wake: make(chan struct{}, 1)}
+	},
+}
+
+// newIrq: get an irq from the pool, it is owned by the caller until release.
+func newIrq(ih UscallHandler, reg UscallRegister, sig INT_SIGNAL) *irq {
+	i := irqPool.Get().(*irq)
+	i.ih, i.reg, i.sig = ih, reg, sig
+	i.refs.Store(1)
+	return i
+}
+
+// hold: the irq is referred by the controller until it is released.
+func (i *irq) hold() *irq {
+	i.refs.Add(1)
+	return i
+}
+
+// release: drop the reference, the irq is reset and put back to pool by the last one.
+func (i *irq) release() {
+	if i.refs.Add(-1) != 0 {
+		return
+	}
+
+	select {
+	case <-i.wake: // drain the signal not received by listen
+	default:
+	}
+	i.src, i.sig, i.seq, i.links = INT_SRC_NONE, 0, 0, [irqLinks]irqLink{}
+	i.retry, i.n, i.err, i.any, i.ih, i.reg, i.notify, i.token = 0, 0, nil, nil, nil, nil, nil, 0
+	i.state.Store(IRQ_PENDING)
+	i.reaped.Store(false)
+	irqPool.Put(i)
 }
 
 func (i *irq) Error() error {
@@ -45,31 +164,59 @@ func (i *irq) bind(sig INT_SIGNAL) *irq {
 	return i
 }
 
+// signal: wake the listener of irq, never blocks the interrupter.
+func (i *irq) signal() {
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
+}
+
+// begin: run the io of pending irq on controller thread, return false if it is done.
+func (i *irq) begin() bool {
+	return i.state.CompareAndSwap(IRQ_PENDING, IRQ_RUNNING)
+}
+
+// pause: the running irq is pending again, it would wait for the next event.
+func (i *irq) pause() {
+	i.state.Store(IRQ_PENDING)
+}
+
+// claim: complete the pending irq, return false if it is running or done.
+func (i *irq) claim() bool {
+	return i.state.CompareAndSwap(IRQ_PENDING, IRQ_DONE)
+}
+
+/*
+irqHandler queue the trapped irqs by signal in FIFO order.
+
+	An interrupt on a signal walks its queue only, the oldest irq is matched first,
+	so the goroutines blocked in Read, Write or Accept are served in the order they arrived.
+*/
 type irqHandler struct {
-	sync.Cond
 	sync.RWMutex
-	irqList list.List
-	seq     int64
+	queues [irqQueues]irqQueue
+	seq    int64
 }
 
 func newIrqHandler() *irqHandler {
-	ih := &irqHandler{}
-	ih.Cond.L = &ih.RWMutex
-	return ih
+	return &irqHandler{}
 }
 
 func (in *irqHandler) ctl_add(i *irq) {
-	if i.le == nil {
+	if !i.links[linkHandler].queued {
 		i.seq = in.seq
 		in.seq++
-		i.le = in.irqList.PushBack(i)
+		in.queues[queueOf(i.sig)].push(i, linkHandler)
+		if i.wake == nil {
+			i.wake = make(chan struct{}, 1)
+		}
 	}
 }
 
 func (in *irqHandler) ctl_delete(i *irq) {
-	if i.le != nil {
-		in.irqList.Remove(i.le)
-		i.le = nil
+	if i.links[linkHandler].queued {
+		in.queues[queueOf(i.sig)].remove(i, linkHandler)
 	}
 }
 
@@ -84,39 +231,77 @@ func (in *irqHandler) untrap(i *irq) {
 }
 
 func (in *irqHandler) listen(i *irq) error {
+	// only the interrupted irq is woken, the others keep sleeping.
 	for i.src == INT_SRC_NONE {
-		in.Wait()
+		in.Unlock()
+		<-i.wake
+		in.Lock()
 	}
 	return i.Error()
 }
 
-type matchFunc func(*irq) bool
+// matchFunc: match the irqs in the queue of sig, or the irq only if it is not nil.
+type matchFunc struct {
+	sig   INT_SIGNAL
+	iReq  *irq
+	match func(*irq) bool
+}
 
 func equalMf(sig INT_SIGNAL, data interface{}) matchFunc {
-	return func(i *irq) bool {
-		if i.sig == sig {
+	return matchFunc{sig: sig, match: func(i *irq) bool {
+		if i.claim() {
 			i.any = data
 			return true
 		}
 		return false
-	}
+	}}
+}
+
+// claimMf: complete the pending irq of sig, its any is still read by the controller which
+// may be preparing it.
+func claimMf(sig INT_SIGNAL) matchFunc {
+	return matchFunc{sig: sig, match: func(i *irq) bool {
+		return i.claim()
+	}}
 }
 
 func errorMf(err error) matchFunc {
-	return func(i *irq) bool {
-		i.err = err
+	return matchFunc{sig: INT_SIG_ANY, match: func(i *irq) bool {
+		if i.claim() {
+			i.err = err
+			return true
+		}
+		return false
+	}}
+}
+
+// doneMf: match the irq run by the controller, its result has been written.
+func doneMf(iReq *irq) matchFunc {
+	return matchFunc{iReq: iReq, match: func(i *irq) bool {
+		i.state.Store(IRQ_DONE)
 		return true
-	}
+	}}
 }
 
 func errorWrapMf(m matchFunc, err error) matchFunc {
-	return func(i *irq) bool {
-		if m(i) {
+	match := m.match
+	m.match = func(i *irq) bool {
+		if match(i) {
 			i.err = err
 			return true
 		}
 		return false
 	}
+	return m
+}
+
+// listenerMf: match the irqs waited by the listeners only, the submitted ones are skipped.
+func listenerMf(m matchFunc) matchFunc {
+	match := m.match
+	m.match = func(i *irq) bool {
+		return i.notify == nil && match(i)
+	}
+	return m
 }
 
 // send: send the signal, if all is true, trigger all irqs  match the signal in list,
@@ -125,46 +310,93 @@ func (in *irqHandler) interrupt(iSrc INT_SOURCE, mf matchFunc, all bool, ops ...
 	in.Lock()
 	// defer in.Unlock()
 
-	// must call op before signal broadcast
+	// must call op before waking the irqs
 	for _, op := range ops {
 		op()
 	}
 
-	for i := in.irqList.Front(); i != nil; i = i.Next() {
-		if ii := i.Value.(*irq); mf(ii) {
-			ii.src = iSrc
-			in.irqList.Remove(i)
-			if !all {
-				break
+	if mf.iReq != nil { // the irq is found without walking
+		if mf.iReq.links[linkHandler].queued && mf.match(mf.iReq) {
+			in.wake(iSrc, mf.iReq)
+		}
+		in.Unlock()
+		return
+	}
+
+	for q := range in.queues {
+		if mf.sig != INT_SIG_ANY && q != queueOf(mf.sig) {
+			continue
+		}
+		for i, next := in.queues[q].front, (*irq)(nil); i != nil; i = next {
+			next = i.links[linkHandler].next // next is nil after removed
+			if (mf.sig == INT_SIG_ANY || i.sig == mf.sig) && mf.match(i) {
+				in.wake(iSrc, i)
+				if !all {
+					in.Unlock()
+					return
+				}
 			}
 		}
 	}
 	in.Unlock()
-	in.Cond.Broadcast()
 }
 
-type irqRegister sync.Map
+// wake: remove the matched irq from its queue and wake its listener. The submitted irq is
+// notified, unless its handler cancels the io in flight and notifies it once the io is done.
+func (in *irqHandler) wake(iSrc INT_SOURCE, i *irq) {
+	i.src = iSrc
+	in.queues[queueOf(i.sig)].remove(i, linkHandler)
+	if i.notify != nil {
+		if c, ok := i.ih.(UscallCanceller); !ok || !c.Cancel(i) {
+			i.notify(i)
+		}
+		return
+	}
+	i.signal()
+}
+
+/*
+irqRegister queue the irqs waiting for the readiness of fd by signal in FIFO order.
+
+	It is owned by the controller thread, no lock is required.
+	A readiness event dispatches the oldest irq of the signal first.
+*/
+type irqRegister struct {
+	queues [irqQueues]irqQueue
+}
 
 func (ir *irqRegister) Save(i *irq) {
-	(*sync.Map)(ir).Store(i, struct{}{})
+	if !i.links[linkRegister].queued {
+		ir.queues[queueOf(i.sig)].push(i, linkRegister)
+	}
 }
 
 func (ir *irqRegister) Remove(i *irq) {
-	(*sync.Map)(ir).Delete(i)
+	if i.links[linkRegister].queued {
+		ir.queues[queueOf(i.sig)].remove(i, linkRegister)
+	}
+}
+
+// Front: return the oldest irq of sig, nil if there is none.
+func (ir *irqRegister) Front(sig INT_SIGNAL) *irq {
+	return ir.queues[queueOf(sig)].front
 }
 
 func (ir *irqRegister) Range(f func(*irq) bool) {
-	(*sync.Map)(ir).Range(func(key, value any) bool {
-		if i, ok := key.(*irq); ok {
-			return f(i)
+	for q := range ir.queues {
+		for i, next := ir.queues[q].front, (*irq)(nil); i != nil; i = next {
+			next = i.links[linkRegister].next // next is nil after removed
+			if !f(i) {
+				return
+			}
 		}
-		return true
-	})
+	}
 }
 
 /********************************interface define *******************/
 type UscallController interface {
 	Serve(*irq)
+	Complete(*Op)
 }
 
 type UscallHandler interface {
@@ -172,9 +404,17 @@ type UscallHandler interface {
 	Error(*irq, error)
 }
 
+// UscallCanceller is implemented by the handlers whose io may be in flight after the irq is
+// completed by others. Cancel returns true if the io is cancelled, the handler notifies the
+// irq once it is done.
+type UscallCanceller interface {
+	Cancel(*irq) (pending bool)
+}
+
 type UscallRegister interface {
 	Save(*irq)
 	Remove(*irq)
+	Front(INT_SIGNAL) *irq
 	Range(func(*irq) bool)
 	FD() int32
 }
@@ -17,7 +17,7 @@ func TestInterrupt1vs1(t *testing.T) {
 	}()
 
 	call(t, ih, data, nil)
-	assert.Equal(t, 0, ih.irqList.Len())
+	assert.Equal(t, 0, ih.queues[queueOf(INT_SIG_INPUT)].Len())
 }
 
 func TestInterrupt1vsN(t *testing.T) {
@@ -40,7 +40,7 @@ func TestInterrupt1vsN(t *testing.T) {
 	ih.interrupt(INT_SRC_TEST, equalMf(INT_SIG_INPUT, data), true)
 	end.Wait()
 
-	assert.Equal(t, 0, ih.irqList.Len())
+	assert.Equal(t, 0, ih.queues[queueOf(INT_SIG_INPUT)].Len())
 }
 
 func TestInterrupt1vsNError(t *testing.T) {
@@ -63,7 +63,106 @@ func TestInterrupt1vsNError(t *testing.T) {
 	ih.interrupt(INT_SRC_TEST, errorMf(err), true)
 	end.Wait()
 
-	assert.Equal(t, 0, ih.irqList.Len())
+	assert.Equal(t, 0, ih.queues[queueOf(INT_SIG_INPUT)].Len())
+}
+
+func TestInterruptFIFO(t *testing.T) {
+	ih := newIrqHandler()
+
+	n, end := 100, sync.WaitGroup{}
+	end.Add(n)
+	for i := 0; i < n; i++ {
+		start := sync.WaitGroup{}
+		start.Add(1)
+		go func() {
+			defer end.Done()
+			var iReq = (&irq{}).bind(INT_SIG_INPUT)
+			ih.trap(iReq)
+			defer ih.untrap(iReq)
+			start.Done()
+			assert.NoError(t, ih.listen(iReq))
+			assert.Equal(t, i, iReq.any) // the oldest irq is woken first
+		}()
+		start.Wait()
+	}
+
+	// the output irq is not matched by the input signal.
+	out := (&irq{}).bind(INT_SIG_OUTPUT)
+	ih.trap(out)
+	ih.untrap(out)
+
+	for i := 0; i < n; i++ {
+		ih.interrupt(INT_SRC_TEST, equalMf(INT_SIG_INPUT, i), false)
+	}
+	end.Wait()
+
+	assert.Equal(t, INT_SRC_NONE, out.src)
+	assert.Equal(t, 0, ih.queues[queueOf(INT_SIG_INPUT)].Len())
+}
+
+// testCanceller: the io of irq is in flight until done.
+type testCanceller struct {
+	done     bool
+	canceled int
+}
+
+func (h *testCanceller) Handle(iReq *irq) bool      { return true }
+func (h *testCanceller) Error(iReq *irq, err error) {}
+func (h *testCanceller) Cancel(iReq *irq) bool {
+	h.canceled++
+	return !h.done
+}
+
+func TestInterruptCanceller(t *testing.T) {
+	ih, h, notified := newIrqHandler(), &testCanceller{}, 0
+	submit := func() {
+		iReq := (&irq{ih: h, notify: func(*irq) { notified++ }}).bind(INT_SIG_INPUT)
+		ih.trap(iReq)
+		ih.Unlock()
+	}
+
+	// the io in flight is cancelled, the irq is left to its handler.
+	submit()
+	ih.interrupt(INT_SRC_TEST, errorMf(io.EOF), false)
+	assert.Equal(t, 1, h.canceled)
+	assert.Equal(t, 0, notified)
+
+	// the io done is notified at once.
+	h.done = true
+	submit()
+	ih.interrupt(INT_SRC_TEST, errorMf(io.EOF), false)
+	assert.Equal(t, 2, h.canceled)
+	assert.Equal(t, 1, notified)
+	assert.Equal(t, 0, ih.queues[queueOf(INT_SIG_INPUT)].Len())
+}
+
+func TestIrqRegister(t *testing.T) {
+	ir := &irqRegister{}
+	in := []*irq{(&irq{}).bind(INT_SIG_INPUT), (&irq{}).bind(INT_SIG_INPUT)}
+	out := (&irq{}).bind(INT_SIG_OUTPUT)
+
+	ir.Save(in[0])
+	ir.Save(out)
+	ir.Save(in[1])
+	ir.Save(in[0]) // saved once
+
+	assert.Equal(t, in[0], ir.Front(INT_SIG_INPUT))
+	assert.Equal(t, out, ir.Front(INT_SIG_OUTPUT))
+	assert.Nil(t, ir.Front(INT_SIG_EXP))
+
+	ir.Remove(in[0])
+	ir.Remove(in[0])
+	assert.Equal(t, in[1], ir.Front(INT_SIG_INPUT))
+
+	count := 0
+	ir.Range(func(i *irq) bool {
+		ir.Remove(i)
+		count++
+		return true
+	})
+	assert.Equal(t, 2, count)
+	assert.Nil(t, ir.Front(INT_SIG_INPUT))
+	assert.Nil(t, ir.Front(INT_SIG_OUTPUT))
 }
 
 func BenchmarkInterrupt(b *testing.B) {
@@ -71,12 +170,9 @@ func BenchmarkInterrupt(b *testing.B) {
 	data := "input_XXXX"
 
 	for i := 0; i < b.N; i++ {
-		stop := false
-		go func() {
+		call_fire(b, ih, data, nil, func() {
 			ih.interrupt(INT_SRC_TEST, equalMf(INT_SIG_INPUT, data), false)
-			stop = true //avoid block
-		}()
-		call_stop(b, ih, data, nil, &stop)
+		})
 	}
 }
 
@@ -86,35 +182,66 @@ func BenchmarkInterruptParral(b *testing.B) {
 
 	b.RunParallel(func(p *testing.PB) {
 		for p.Next() {
-			stop := false
-			go func() {
+			call_fire(b, ih, data, nil, func() {
 				ih.interrupt(INT_SRC_TEST, equalMf(INT_SIG_INPUT, data), true)
-				stop = true //avoid block
-			}()
-			call_stop(b, ih, data, nil, &stop)
+			})
 		}
 	})
 }
 
-const INT_SRC_TEST = iota + 1000
+// BenchmarkInterruptIdle: the idle waiters on other signal should not be woken.
+func BenchmarkInterruptIdle(b *testing.B) {
+	ih := newIrqHandler()
+	data := "input_XXXX"
 
-var stop = false
+	n, idle, start := 1000, sync.WaitGroup{}, sync.WaitGroup{}
+	idle.Add(n)
+	start.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer idle.Done()
+			var iReq = (&irq{}).bind(INT_SIG_OUTPUT)
+			ih.trap(iReq)
+			defer ih.untrap(iReq)
+			start.Done()
+			ih.listen(iReq)
+		}()
+	}
+	start.Wait()
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		for p.Next() {
+			call_fire(b, ih, data, nil, func() {
+				ih.interrupt(INT_SRC_TEST, equalMf(INT_SIG_INPUT, data), true)
+			})
+		}
+	})
+	b.StopTimer()
+
+	ih.interrupt(INT_SRC_TEST, equalMf(INT_SIG_OUTPUT, nil), true)
+	idle.Wait()
+}
+
+const INT_SRC_TEST = iota + 1000
 
 func call(t assert.TestingT, ih *irqHandler, data interface{}, err error) {
-	call_stop(t, ih, data, err, &stop)
+	call_fire(t, ih, data, err, nil)
 }
 
-func call_stop(t assert.TestingT, ih *irqHandler, data interface{}, err error, stop *bool) {
+// call_fire: fire the interrupt after the irq trapped, it is blocked until listen.
+func call_fire(t assert.TestingT, ih *irqHandler, data interface{}, err error, fire func()) {
 	var iReq = (&irq{}).bind(INT_SIG_INPUT)
 	ih.trap(iReq)
 	defer ih.untrap(iReq)
-	for iReq.any == nil && iReq.err == nil && !*stop {
+	if fire != nil {
+		go fire()
+	}
+	for iReq.any == nil && iReq.err == nil {
 		if !assert.Equal(t, err, ih.listen(iReq)) {
 			break
 		}
 	}
 	// t.Logf("the test is end, receive signal: %s", iReq.any)
-	if !*stop {
-		assert.Equal(t, data, iReq.any)
-	}
+	assert.Equal(t, data, iReq.any)
 }
@@ -6,7 +6,7 @@ import (
 	"strings"
 )
 
-func Listen(network, address string) (net.Listener, error) {
+func Listen(network, address string, opts ...Option) (net.Listener, error) {
 
 	switch strings.ToLower(network) {
 	case "tcp", "tcp4", "tcp6":
@@ -17,7 +17,11 @@ func Listen(network, address string) (net.Listener, error) {
 				return nil, err
 			}
 
-			return createTCPListener(addr)
+			o := options{pool: defaultPoolLimit}
+			for _, opt := range opts {
+				opt(&o)
+			}
+			return createTCPListener(addr, &o)
 		}
 	default:
 		return nil, errors.New(network + "is not supportted now.")
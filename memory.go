@@ -0,0 +1,139 @@
+package usnet
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const pressureCheck = 10 * time.Millisecond // the interval the deferred ops check the close and deadline
+
+// MemoryStats: the counters of the memory budget of all connections.
+type MemoryStats struct {
+	Limit int64 // the budget, zero is unlimited
+	Used  int64 // the bytes of buffers allocated, the free ones in pools and the pending writes included
+
+	Pressures       uint64        // the times the budget came under pressure
+	PressureTime    time.Duration // the time spent under pressure
+	DeferredAccepts uint64        // the accepts paused by the pressure and resumed as it dropped
+	DeferredReads   uint64        // the first reads of new connections deferred until the pressure dropped
+	DeferredTime    time.Duration // the time the accepts and reads were deferred
+}
+
+/*
+memBudget is the memory budget shared by all listeners and connections of the runtime.
+
+	The C memory of buffers is charged when allocated and uncharged when freed, the data
+	queued to be written is charged until it is sent. The budget is under pressure from
+	7/8 of the limit until it falls below 3/4, meanwhile the accepts pause, the first reads
+	of new connections are deferred, and the buffers put back to pools are freed, so the
+	connections served could drain and release the memory.
+*/
+type memBudget struct {
+	limit, used int64
+	pressured   int32
+
+	l      sync.Mutex
+	since  time.Time     // the pressure began
+	relief chan struct{} // closed when the pressure is relieved
+	stats  MemoryStats
+}
+
+var memory memBudget
+
+// SetMemoryLimit sets the memory budget of buffers of all connections and returns the previous
+// one, zero is unlimited, which is the default. See MemoryStats for the pressure.
+func SetMemoryLimit(limit int64) int64 {
+	prev := atomic.SwapInt64(&memory.limit, limit)
+	memory.update()
+	return prev
+}
+
+// ReadMemoryStats returns the counters of the memory budget.
+func ReadMemoryStats() MemoryStats {
+	return memory.read()
+}
+
+func (m *memBudget) charge(n int) {
+	used, limit := atomic.AddInt64(&m.used, int64(n)), atomic.LoadInt64(&m.limit)
+	if limit > 0 && used >= limit-limit/8 && !m.pressure() {
+		m.update()
+	}
+}
+
+func (m *memBudget) uncharge(n int) {
+	used, limit := atomic.AddInt64(&m.used, -int64(n)), atomic.LoadInt64(&m.limit)
+	if m.pressure() && used < limit-limit/4 {
+		m.update()
+	}
+}
+
+func (m *memBudget) pressure() bool {
+	return atomic.LoadInt32(&m.pressured) == 1
+}
+
+// update: enter or leave the pressure by the used bytes and limit.
+func (m *memBudget) update() {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	used, limit, on := atomic.LoadInt64(&m.used), atomic.LoadInt64(&m.limit), m.pressure()
+	if !on && limit > 0 && used >= limit-limit/8 {
+		m.since, m.relief = time.Now(), make(chan struct{})
+		m.stats.Pressures++
+		atomic.StoreInt32(&m.pressured, 1)
+	} else if on && (limit <= 0 || used < limit-limit/4) {
+		m.stats.PressureTime += time.Since(m.since)
+		close(m.relief)
+		atomic.StoreInt32(&m.pressured, 0)
+	}
+}
+
+// wait: block while under pressure, the accept or read deferred is counted once the pressure drops.
+// It returns the error of fd, which is checked every pressureCheck for the close and deadline.
+func (m *memBudget) wait(accept bool, fd *fdesc, mode int) (err error) {
+	if !m.pressure() {
+		return nil
+	}
+
+	start := time.Now()
+	t := time.NewTimer(pressureCheck)
+	defer t.Stop()
+	for {
+		m.l.Lock()
+		relief := m.relief
+		if err != nil {
+			m.l.Unlock()
+			return
+		} else if !m.pressure() {
+			if accept {
+				m.stats.DeferredAccepts++
+			} else {
+				m.stats.DeferredReads++
+			}
+			m.stats.DeferredTime += time.Since(start)
+			m.l.Unlock()
+			return
+		}
+		m.l.Unlock()
+
+		select {
+		case <-relief:
+		case <-t.C:
+			t.Reset(pressureCheck)
+		}
+		err = fd.isOk(mode)
+	}
+}
+
+func (m *memBudget) read() MemoryStats {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	stats := m.stats
+	stats.Limit, stats.Used = atomic.LoadInt64(&m.limit), atomic.LoadInt64(&m.used)
+	if m.pressure() {
+		stats.PressureTime += time.Since(m.since)
+	}
+	return stats
+}
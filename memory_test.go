@@ -0,0 +1,97 @@
+package usnet
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemBudget(t *testing.T) {
+	m := &memBudget{limit: 8192}
+	fd := &fdesc{}
+
+	// under pressure from 7/8 of the limit until it falls below 3/4.
+	m.charge(7167)
+	assert.False(t, m.pressure())
+	m.charge(1)
+	assert.True(t, m.pressure())
+	m.uncharge(1024)
+	assert.True(t, m.pressure())
+
+	done := make(chan error)
+	go func() {
+		done <- m.wait(false, fd, 'r')
+	}()
+	select {
+	case <-done:
+		t.Fatal("the read is not deferred")
+	case <-time.After(30 * time.Millisecond):
+	}
+	m.uncharge(1)
+	assert.NoError(t, <-done)
+	assert.False(t, m.pressure())
+	assert.NoError(t, m.wait(true, fd, 'r')) // not deferred
+
+	stats := m.read()
+	assert.Equal(t, int64(8192), stats.Limit)
+	assert.Equal(t, int64(6143), stats.Used)
+	assert.Equal(t, uint64(1), stats.Pressures)
+	assert.Equal(t, uint64(1), stats.DeferredReads)
+	assert.Zero(t, stats.DeferredAccepts)
+	assert.GreaterOrEqual(t, stats.PressureTime, stats.DeferredTime)
+	assert.GreaterOrEqual(t, stats.DeferredTime, 30*time.Millisecond)
+
+	// the deferred accept returns the error of fd, it is not counted.
+	m.charge(2048)
+	go func() {
+		done <- m.wait(true, fd, 'r')
+	}()
+	fd.status.set(CLOSED)
+	assert.ErrorIs(t, <-done, net.ErrClosed)
+	stats = m.read()
+	assert.Zero(t, stats.DeferredAccepts)
+	assert.Equal(t, uint64(1), stats.DeferredReads)
+}
+
+func TestListenMemoryLimit(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", addr, port+10)
+	l, err := Listen("tcp", address, WithBufferPool(0))
+	assert.NoError(t, err)
+	defer l.Close()
+
+	// the accept pauses until the budget is raised.
+	b := newBuffer(1024)
+	defer b.free()
+	stats := ReadMemoryStats()
+	SetMemoryLimit(stats.Used)
+	defer SetMemoryLimit(0)
+	client, err := testDialer("tcp", address)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := l.Accept()
+		accepted <- conn
+	}()
+	select {
+	case <-accepted:
+		t.Fatal("the accept is not paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	SetMemoryLimit(0)
+	conn := <-accepted
+	assert.NotNil(t, conn)
+
+	after := ReadMemoryStats()
+	assert.Equal(t, stats.DeferredAccepts+1, after.DeferredAccepts)
+	assert.Equal(t, stats.Pressures+1, after.Pressures)
+	assert.Greater(t, after.Used, stats.Used) // the buffers of conn
+
+	// the buffers are freed by Close over the pool limit, not left to the finalizer.
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, stats.Used, ReadMemoryStats().Used)
+}
@@ -3,27 +3,121 @@ package usnet
 import (
 	"errors"
 	"sync"
-	"syscall"
-	"unsafe"
+	"sync/atomic"
 	"usnet/uscall"
+)
 
-	"github.com/redresseur/utils/structure"
+const (
+	fdTableInit = 1024
+	pollEvents  = 4096 // the default size of event array
 )
 
+/*
+fdTable is a dense fd-indexed table of the fdescs added into netpoller.
+
+	The user data of epoll event is the token of fdesc: the fd in the low 32 bits,
+	and the generation of registration in the high 32 bits. The table is looked up
+	without lock, a token is invalidated when the fd is deleted, so the stale events
+	of a closed or reused fd are dropped.
+*/
+type fdTable struct {
+	l     sync.Mutex // protect the writers
+	gen   uint32
+	slots atomic.Pointer[[]atomic.Pointer[fdesc]]
+}
+
+func (t *fdTable) grow(fd int32) []atomic.Pointer[fdesc] {
+	var slots []atomic.Pointer[fdesc]
+	if p := t.slots.Load(); p != nil {
+		if slots = *p; int(fd) < len(slots) {
+			return slots
+		}
+	}
+
+	n := fdTableInit
+	for n <= int(fd) || n < 2*len(slots) {
+		n <<= 1
+	}
+	nslots := make([]atomic.Pointer[fdesc], n)
+	for i := range slots {
+		nslots[i].Store(slots[i].Load())
+	}
+	t.slots.Store(&nslots)
+	return nslots
+}
+
+// store: save the fd into table, return the token.
+func (t *fdTable) store(fd *fdesc) uint64 {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	slots := t.grow(fd.fd)
+	if t.gen++; t.gen == 0 { // zero token is invalid
+		t.gen++
+	}
+
+	token := uint64(t.gen)<<32 | uint64(uint32(fd.fd))
+	fd.token.Store(token)
+	slots[fd.fd].Store(fd)
+	return token
+}
+
+func (t *fdTable) delete(fd *fdesc) bool {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	if fd.token.Swap(0) == 0 {
+		return false
+	}
+	if p := t.slots.Load(); p != nil && int(fd.fd) < len(*p) {
+		(*p)[fd.fd].CompareAndSwap(fd, nil)
+	}
+	return true
+}
+
+func (t *fdTable) load(token uint64) *fdesc {
+	fd := int(uint32(token))
+	if p := t.slots.Load(); p != nil && fd < len(*p) {
+		if v := (*p)[fd].Load(); v != nil && v.token.Load() == token {
+			return v
+		}
+	}
+	return nil
+}
+
 type netpoller struct {
-	epfd     int32
-	fdIndexs sync.Map
-	events   [4096]uscall.Epoll_event
-	ref      int64
+	epfd   int32
+	fds    fdTable
+	events []uscall.Epoll_event
+	ref    int64
+	et     bool // register the fds in edge-triggered mode
+
+	wl   sync.Mutex // protect evfd from the close while waking
+	evfd int32      // the eventfd waking the blocked wait, -1 if it is not supported
 }
 
-func createNetPoller() (*netpoller, error) {
+// createNetPoller: events is the size of event array, pollEvents if zero.
+func createNetPoller(events int) (*netpoller, error) {
 	epfd, err := uscall.UscallEpollCreate(0)
 	if err != nil {
 		return nil, err
 	}
 
-	return &netpoller{epfd: int32(epfd)}, nil
+	if events <= 0 {
+		events = pollEvents
+	}
+	p := &netpoller{epfd: int32(epfd), evfd: -1, events: make([]uscall.Epoll_event, events)}
+
+	// the eventfd takes the zero token, which is never found in fdTable.
+	if evfd, err := uscall.UscallEventfd(); err == nil {
+		ev := (&uscall.Epoll_event{}).SetEvents(uscall.EPOLLIN).SetData(0)
+		if _, err = uscall.UscallEpollCtl(p.epfd, uscall.EPOLL_CTL_ADD, evfd, ev); err != nil {
+			uscall.UscallClose(evfd)
+		} else {
+			p.evfd = evfd
+		}
+	}
+	return p, nil
 }
 
 func (p *netpoller) close() {
@@ -31,19 +125,37 @@ func (p *netpoller) close() {
 		uscall.UscallClose(p.epfd)
 		p.epfd = -1
 	}
+
+	p.wl.Lock()
+	defer p.wl.Unlock()
+	if p.evfd >= 0 {
+		uscall.UscallClose(p.evfd)
+		p.evfd = -1
+	}
+}
+
+// wake: wake the wait blocked in epoll_wait, it is called by any goroutine.
+func (p *netpoller) wake() {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+	if p.evfd >= 0 {
+		uscall.UscallEventfdWrite(p.evfd)
+	}
 }
 
 func (p *netpoller) ctl_add(fd *fdesc, ev *uscall.Epoll_event) error {
+	// store before adding, the events may be reported at once.
+	ev.SetData(p.fds.store(fd))
 	if _, err := uscall.UscallEpollCtl(p.epfd, uscall.EPOLL_CTL_ADD, fd.FD(), ev); err != nil {
+		p.fds.delete(fd)
 		return err
 	}
-	p.fdIndexs.Store(fd.FD(), fd)
 	p.ref++
 	return nil
 }
 
 func (p *netpoller) ctl_modify(fd *fdesc, ev *uscall.Epoll_event) error {
-	if _, ok := p.fdIndexs.Load(fd.FD()); !ok {
+	if fd.token.Load() == 0 {
 		return errors.New("the specify fd has not been added into poller")
 	}
 	if _, err := uscall.UscallEpollCtl(p.epfd, uscall.EPOLL_CTL_MOD, fd.FD(), ev); err != nil {
@@ -53,7 +165,7 @@ func (p *netpoller) ctl_modify(fd *fdesc, ev *uscall.Epoll_event) error {
 }
 
 func (p *netpoller) ctl_delete(fd *fdesc, ev *uscall.Epoll_event) error {
-	if _, ok := p.fdIndexs.LoadAndDelete(fd.FD()); !ok {
+	if !p.fds.delete(fd) {
 		return errors.New("the specify fd has not been added into poller")
 	}
 	if _, err := uscall.UscallEpollCtl(p.epfd, uscall.EPOLL_CTL_DEL, fd.FD(), ev); err != nil {
@@ -64,109 +176,23 @@ func (p *netpoller) ctl_delete(fd *fdesc, ev *uscall.Epoll_event) error {
 }
 
 func (p *netpoller) wait(timeout int32) ([]uscall.Epoll_event, error) {
-	n, err := uscall.UscallEpollWait(p.epfd, &p.events[0], 4096, timeout)
+	n, err := uscall.UscallEpollWait(p.epfd, &p.events[0], int32(len(p.events)), timeout)
 	if err != nil {
 		return nil, err
 	}
-	return p.events[:n], nil
-}
-
-func (p *netpoller) getFd(fd int32) *fdesc {
-	v, ok := p.fdIndexs.Load(fd)
-	if !ok {
-		return nil
-	}
-	return v.(*fdesc)
-}
-
-// uscallController implement UscallController
-type uscallController struct {
-	p       *netpoller
-	irQueue *structure.Queue
-}
-
-func NewUscallController(p *netpoller) *uscallController {
-	return &uscallController{
-		p:       p,
-		irQueue: structure.NewQueue(1),
-	}
-}
-
-func (c *uscallController) Serve(iReq *irq) {
-	c.irQueue.Push(iReq)
-	c.irQueue.SingleUP(false)
-}
-
-func (c *uscallController) proc() {
-	uscall.UscallRun(func(p unsafe.Pointer) int32 {
-
-		if c.p.ref == 0 {
-			<-c.irQueue.Single()
-		}
-		for v := c.irQueue.Pop(); v != nil; v = c.irQueue.Pop() {
-			if iReq, ok := v.(*irq); ok {
-				if !iReq.ih.Handle(iReq) {
-					iReq.reg.Save(iReq)
-				}
-			}
-		}
 
-		if c.p.ref == 0 {
-			return 0
+	events := p.events[:n]
+	for i := range events {
+		if events[i].Data() == 0 { // woken, the eventfd is not reported
+			uscall.UscallEventfdRead(p.evfd)
+			events = append(events[:i], events[i+1:]...)
+			break
 		}
-
-		// wait events
-		if events, err := c.p.wait(0); err != nil {
-			return -1
-		} else {
-			for _, ev := range events {
-				c.handle(&ev)
-			}
-		}
-		return 0
-	}, nil)
-}
-
-func (c *uscallController) getReg(ev *uscall.Epoll_event) UscallRegister {
-	if v := c.p.getFd(ev.Socket()); v != nil {
-		return v
 	}
-	return nil
+	return events, nil
 }
 
-func (c *uscallController) handle(ev *uscall.Epoll_event) {
-	var efd UscallRegister
-	if efd = c.getReg(ev); efd == nil {
-		return
-	}
-
-	if ev.Event()&uscall.EPOLLIN != 0 {
-		efd.Range(func(i *irq) (res bool) {
-			if res = (i.sig == INT_SIG_INPUT); res {
-				if i.ih.Handle(i) {
-					efd.Remove(i)
-				}
-			}
-			return
-		})
-	}
-
-	if ev.Event()&uscall.EPOLLOUT != 0 {
-		efd.Range(func(i *irq) (res bool) {
-			if res = (i.sig == INT_SIG_OUTPUT); res {
-				if i.ih.Handle(i) {
-					efd.Remove(i)
-				}
-			}
-			return
-		})
-	}
-
-	if ev.Event()&uscall.EPOLLERR != 0 {
-		efd.Range(func(i *irq) bool {
-			i.ih.Error(i, syscall.EINVAL)
-			efd.Remove(i)
-			return true
-		})
-	}
+// getFd: find the fd by the token in event data, return nil if the fd has been deleted.
+func (p *netpoller) getFd(ev *uscall.Epoll_event) *fdesc {
+	return p.fds.load(ev.Data())
 }
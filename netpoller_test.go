@@ -0,0 +1,76 @@
+package usnet
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFdTable(t *testing.T) {
+	tb := &fdTable{}
+	fd := &fdesc{fd: 10}
+
+	token := tb.store(fd)
+	assert.Equal(t, int32(10), int32(uint32(token)))
+	assert.Equal(t, fd, tb.load(token))
+
+	// the token is stale after the fd deleted.
+	assert.True(t, tb.delete(fd))
+	assert.False(t, tb.delete(fd))
+	assert.Nil(t, tb.load(token))
+
+	// the fd number is reused, the old token is still stale.
+	nfd := &fdesc{fd: 10}
+	ntoken := tb.store(nfd)
+	assert.NotEqual(t, token, ntoken)
+	assert.Nil(t, tb.load(token))
+	assert.Equal(t, nfd, tb.load(ntoken))
+
+	// delete the old fd does not evict the new one.
+	assert.False(t, tb.delete(fd))
+	assert.Equal(t, nfd, tb.load(ntoken))
+
+	// grow the table.
+	big := &fdesc{fd: 5000}
+	btoken := tb.store(big)
+	assert.Equal(t, big, tb.load(btoken))
+	assert.Equal(t, nfd, tb.load(ntoken))
+	assert.Nil(t, tb.load(uint64(1)<<32|100000))
+}
+
+func BenchmarkNetpollerLookup(b *testing.B) {
+	const n = 1024
+	tb, m := &fdTable{}, sync.Map{}
+	tokens := make([]uint64, n)
+	for i := range tokens {
+		fd := &fdesc{fd: int32(i)}
+		tokens[i] = tb.store(fd)
+		m.Store(fd.fd, fd)
+	}
+
+	b.Run("table", func(b *testing.B) {
+		b.RunParallel(func(p *testing.PB) {
+			i := 0
+			for p.Next() {
+				if tb.load(tokens[i%n]) == nil {
+					b.Fatal("fd not found")
+				}
+				i++
+			}
+		})
+	})
+
+	b.Run("syncmap", func(b *testing.B) {
+		b.RunParallel(func(p *testing.PB) {
+			i := 0
+			for p.Next() {
+				v, ok := m.Load(int32(i % n))
+				if !ok || v.(*fdesc) == nil {
+					b.Fatal("fd not found")
+				}
+				i++
+			}
+		})
+	})
+}
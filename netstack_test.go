@@ -0,0 +1,20 @@
+//go:build netstack && !syscall
+// +build netstack,!syscall
+
+package usnet
+
+import (
+	"net"
+	"usnet/uscall"
+)
+
+func init() {
+	// the listener is bound on the local netstack, dial it from the peer stack.
+	testDialer = func(network, address string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		return uscall.NetstackDial(net.JoinHostPort(uscall.NetstackAddr, port))
+	}
+}
@@ -0,0 +1,123 @@
+package usnet
+
+import "usnet/uscall"
+
+// Option: the option of Listen.
+type Option func(*options)
+
+type options struct {
+	edgeTriggered bool
+	poll          PollPolicy
+	budget        Budget
+	batch         int
+	pool          int
+	buffer        BufferPolicy
+	alloc         uscall.Allocator
+	queue         *SendQueue
+}
+
+/*
+WithEdgeTriggered: register the sockets into netpoller in edge-triggered mode.
+
+	Each socket is registered once for all events when accepted, the readiness is
+	tracked in the status of fd and cleaned when EAGAIN, so no epoll_ctl is issued
+	for the blocked Read and Write. The io_uring backend ignores it.
+*/
+func WithEdgeTriggered() Option {
+	return func(o *options) {
+		o.edgeTriggered = true
+	}
+}
+
+/*
+WithPollPolicy: set how the controller loop waits for the events when it is idle.
+
+	The default is PollBusy, the loop polls without timeout while there is any socket.
+	PollBlock and PollHybrid save the core of an idle listener, see PollPolicy.
+*/
+func WithPollPolicy(p PollPolicy) Option {
+	return func(o *options) {
+		o.poll = p
+	}
+}
+
+/*
+WithBudget: bound the work of one pass of the controller loop.
+
+	The irqs and the io beyond the budget are carried over to the next pass,
+	so a flood of submissions or a bulk connection can not starve the others.
+*/
+func WithBudget(b Budget) Option {
+	return func(o *options) {
+		o.budget = b
+	}
+}
+
+/*
+WithBatch: execute the io of the irqs popped in one pass by one cgo call.
+
+	The reads and writes are added into a batch of size ops at most, and the batch
+	is flushed once per pass or when it is full, so the cost of cgo crossing is paid
+	once instead of per io. The io_uring backend ignores it, the sqes are submitted in
+	batch already.
+*/
+func WithBatch(size int) Option {
+	return func(o *options) {
+		o.batch = size
+	}
+}
+
+/*
+WithBufferPool: keep limit bytes of the free buffers of connections at most.
+
+	The read and write buffers are taken from the pool on accept and put back on Close,
+	the buffers over the limit are freed at once. The default is 64 MB, the buffers are
+	not kept if limit is not positive. On io_uring the sqes in flight are reaped before
+	the buffers are put back.
+*/
+func WithBufferPool(limit int) Option {
+	return func(o *options) {
+		o.pool = limit
+	}
+}
+
+/*
+WithBufferPolicy: size the buffers of connections by p, see BufferPolicy.
+
+	The buffers grow with the traffic and are released when the connection is idle,
+	so the mostly idle connections hold little memory. It works with the buffer pool,
+	the buffers released are kept in the pool up to its limit.
+*/
+func WithBufferPolicy(p BufferPolicy) Option {
+	return func(o *options) {
+		o.buffer = p
+	}
+}
+
+/*
+WithAllocator: allocate the buffers of connections from a.
+
+	uscall.AllocHugepage backs them by the hugepages on the NUMA node of the controller,
+	rte_malloc_socket on f-stack, or the hugepages mapped by MAP_HUGETLB and bound by mbind
+	on the kernel backend, which must be reserved in /proc/sys/vm/nr_hugepages. The buffers
+	fall back to malloc if no hugepage is available, counted in PoolStats.Fallbacks, the node
+	failed is retried after a while, counted in PoolStats.HugeFailures.
+*/
+func WithAllocator(a uscall.Allocator) Option {
+	return func(o *options) {
+		o.alloc = a
+	}
+}
+
+/*
+WithSendQueue: write the connections asynchronously through the send queue, see SendQueue.
+
+	Write returns once the data is queued, and the controller writes the queue when the
+	socket is writable, so the servers of small responses hand off far less. Flush waits
+	for the data written, (*TCPConn).Flush.
+*/
+func WithSendQueue(q SendQueue) Option {
+	return func(o *options) {
+		o.queue = &q
+	}
+}
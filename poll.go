@@ -0,0 +1,122 @@
+package usnet
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// PollMode: how the controller loop waits for the events when it is idle.
+type PollMode int
+
+const (
+	PollBusy   PollMode = iota // poll without timeout, the lowest latency and a whole core
+	PollBlock                  // spin, then block with the timeout
+	PollHybrid                 // spin, then back off the timeout from 1ms up to the max
+)
+
+/*
+PollPolicy: the policy of controller loop.
+
+	The loop is idle when an iteration handles no irq and no event, after Spin idle
+	iterations it blocks in epoll_wait (or io_uring_enter) with a timeout. The blocked
+	loop is woken by the submission through an eventfd in the wait, the wake costs a
+	syscall, so the busy-poll is kept for the latency-critical services.
+	The f-stack loop could not be woken, the io submitted meanwhile is delayed by the
+	timeout at most, the idle_sleep of config.ini is preferred there.
+*/
+type PollPolicy struct {
+	Mode    PollMode
+	Spin    int           // the idle iterations before blocking
+	Timeout time.Duration // the timeout of block, the max one of hybrid, 1ms if zero
+}
+
+/*
+Budget: the work of one pass of the controller loop, zero is unlimited.
+
+	Irqs bounds the submissions handled, the rest stays queued. Events sizes the
+	event array, the events not returned are reported by the next wait. Bytes bounds
+	the io of one connection, at least one read or write is done, and the rest of its
+	irqs are carried over, the io_uring backend ignores it.
+*/
+type Budget struct {
+	Irqs   int // the irqs handled per pass
+	Events int // the events handled per pass, 4096 if zero
+	Bytes  int // the bytes read or written per connection per pass
+}
+
+// PollStats: the counters of controller loop.
+type PollStats struct {
+	Loops   uint64 // the iterations
+	Busy    uint64 // the iterations polled without timeout
+	Blocked uint64 // the iterations blocked with a timeout
+	Idle    uint64 // the iterations handled nothing
+	Parked  uint64 // the iterations parked, there is nothing to poll
+	Carried uint64 // the irqs and dispatches carried over by the budget
+}
+
+// pollLoop: the policy state of controller loop, the counters could be read by any goroutine.
+type pollLoop struct {
+	policy  PollPolicy
+	idle    int           // the successive idle iterations
+	backoff time.Duration // the current timeout of hybrid
+
+	loops, busy, blocked, idles, parked, carried atomic.Uint64
+}
+
+// timeout: the timeout of next wait, zero is returned to poll.
+// pending is true if any work is carried over, the loop must not block.
+func (l *pollLoop) timeout(pending bool) time.Duration {
+	if pending || l.policy.Mode == PollBusy || l.idle < l.policy.Spin {
+		l.busy.Add(1)
+		return 0
+	}
+
+	limit := l.policy.Timeout
+	if limit < time.Millisecond {
+		limit = time.Millisecond
+	}
+
+	l.blocked.Add(1)
+	if l.policy.Mode == PollHybrid {
+		if l.backoff = 2 * l.backoff; l.backoff < time.Millisecond {
+			l.backoff = time.Millisecond
+		} else if l.backoff > limit {
+			l.backoff = limit
+		}
+		return l.backoff
+	}
+	return limit
+}
+
+// done: end the iteration, active is true if any irq or event is handled.
+func (l *pollLoop) done(active bool) {
+	l.loops.Add(1)
+	if active {
+		l.idle, l.backoff = 0, 0
+	} else {
+		l.idle++
+		l.idles.Add(1)
+	}
+}
+
+// park: the loop is parked until the next submission, the state is reset.
+func (l *pollLoop) park() {
+	l.parked.Add(1)
+	l.idle, l.backoff = 0, 0
+}
+
+func (l *pollLoop) stats() PollStats {
+	return PollStats{
+		Loops:   l.loops.Load(),
+		Busy:    l.busy.Load(),
+		Blocked: l.blocked.Load(),
+		Idle:    l.idles.Load(),
+		Parked:  l.parked.Load(),
+		Carried: l.carried.Load(),
+	}
+}
+
+// msec: the timeout of epoll_wait, rounded up.
+func msec(d time.Duration) int32 {
+	return int32((d + time.Millisecond - 1) / time.Millisecond)
+}
@@ -0,0 +1,55 @@
+package usnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollLoop(t *testing.T) {
+	ms := time.Millisecond
+	timeouts := func(l *pollLoop, n int) (ts []time.Duration) {
+		for i := 0; i < n; i++ {
+			ts = append(ts, l.timeout(false))
+			l.done(false)
+		}
+		return
+	}
+
+	// busy: never blocked.
+	l := &pollLoop{}
+	assert.Equal(t, []time.Duration{0, 0, 0}, timeouts(l, 3))
+
+	// block: spin, then the fixed timeout, 1ms if zero.
+	l = &pollLoop{policy: PollPolicy{Mode: PollBlock, Spin: 2}}
+	assert.Equal(t, []time.Duration{0, 0, ms, ms}, timeouts(l, 4))
+	l.policy.Timeout = 5 * ms
+	assert.Equal(t, []time.Duration{5 * ms}, timeouts(l, 1))
+
+	// hybrid: spin, then back off up to the max.
+	l = &pollLoop{policy: PollPolicy{Mode: PollHybrid, Spin: 1, Timeout: 5 * ms}}
+	assert.Equal(t, []time.Duration{0, ms, 2 * ms, 4 * ms, 5 * ms, 5 * ms}, timeouts(l, 6))
+
+	// the carried over work is polled.
+	assert.Equal(t, time.Duration(0), l.timeout(true))
+	l.done(false)
+
+	// an active iteration spins again from the shortest timeout.
+	l.timeout(false)
+	l.done(true)
+	assert.Equal(t, []time.Duration{0, ms, 2 * ms}, timeouts(l, 3))
+
+	// so does the parked loop.
+	l.park()
+	assert.Equal(t, []time.Duration{0, ms}, timeouts(l, 2))
+
+	assert.Equal(t, PollStats{Loops: 13, Busy: 4, Blocked: 9, Idle: 12, Parked: 1}, l.stats())
+}
+
+func TestMsec(t *testing.T) {
+	assert.Equal(t, int32(0), msec(0))
+	assert.Equal(t, int32(1), msec(time.Microsecond))
+	assert.Equal(t, int32(1), msec(time.Millisecond))
+	assert.Equal(t, int32(2), msec(1500*time.Microsecond))
+}
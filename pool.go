@@ -0,0 +1,153 @@
+package usnet
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+	"usnet/uscall"
+)
+
+const (
+	slabMinShift = 10 // the smallest class, 1 KB
+	slabClasses  = 7  // 1 KB ~ 64 KB
+
+	connBufferSize   = 8192     // the read and write buffer of connection
+	defaultPoolLimit = 64 << 20 // the bytes of free buffers kept by default
+)
+
+// PoolStats: the counters of the buffer pool.
+type PoolStats struct {
+	Hits   uint64 // the buffers taken from the free ones
+	Misses uint64 // the buffers allocated
+	Puts   uint64 // the buffers put back and kept
+	Drops  uint64 // the buffers freed at once, over the limit or the classes
+	Free   int    // the bytes of free buffers
+
+	Fallbacks    uint64 // the buffers allocated by malloc as no hugepage is available
+	HugeFailures uint64 // the hugepages of the process failed to allocate, see uscall.HugeFailures
+}
+
+/*
+BufferPolicy is the sizing of the read and write buffers of connections.
+
+	The buffers start at Min bytes, the read buffer doubles when a read fills it and the
+	write buffer grows to hold the bytes written, both up to Max. If Idle is positive,
+	the buffers are put back to the pool after no io for Idle, the read waiting for data
+	waits without its buffer, and they are taken at Min again by the next Read or Write.
+	The default is the fixed buffers of 8 KB.
+*/
+type BufferPolicy struct {
+	Min  int
+	Max  int
+	Idle time.Duration
+}
+
+// normalize: fill the defaults.
+func (p BufferPolicy) normalize() *BufferPolicy {
+	if p.Min <= 0 {
+		p.Min = connBufferSize
+	}
+	if p.Max < p.Min {
+		p.Max = p.Min
+	}
+	return &p
+}
+
+/*
+slabPool is the size-classed pool of the C buffers of connections.
+
+	The size is rounded up to the power of two class from 1 KB to 64 KB, each class
+	keeps its free buffers in a stack. The buffers are taken on accept and put back
+	on Close, the free bytes are capped by limit, and the buffer over the limit or
+	out of the classes is freed at once instead of waiting the finalizer. The buffers
+	are allocated from alloc on node, see WithAllocator.
+*/
+type slabPool struct {
+	l        sync.Mutex
+	free     [slabClasses][]*buffer
+	limit    int
+	alloc    uscall.Allocator
+	node     int
+	counters PoolStats
+}
+
+func newSlabPool(limit int) *slabPool {
+	return &slabPool{limit: limit, node: -1}
+}
+
+// allocate: allocate a buffer of size bytes, the fallback from hugepages is counted.
+func (p *slabPool) allocate(size int) *buffer {
+	b, huge := allocBuffer(uint32(size), p.alloc, p.node)
+	if p.alloc == uscall.AllocHugepage && !huge {
+		p.l.Lock()
+		p.counters.Fallbacks++
+		p.l.Unlock()
+	}
+	return b
+}
+
+// slabClass: the class of size, -1 if it is out of the classes.
+func slabClass(size int) int {
+	class := 0
+	if size > 1<<slabMinShift {
+		class = bits.Len(uint(size-1)) - slabMinShift
+	}
+	if class >= slabClasses {
+		return -1
+	}
+	return class
+}
+
+// get: take a buffer of size bytes at least, it is allocated if there is no free one.
+func (p *slabPool) get(size int) *buffer {
+	class := slabClass(size)
+	if class < 0 {
+		p.l.Lock()
+		p.counters.Misses++
+		p.l.Unlock()
+		return p.allocate(size)
+	}
+
+	p.l.Lock()
+	if n := len(p.free[class]); n > 0 {
+		b := p.free[class][n-1]
+		p.free[class][n-1] = nil
+		p.free[class] = p.free[class][:n-1]
+		p.counters.Hits++
+		p.counters.Free -= len(b.shadow)
+		p.l.Unlock()
+		return b.setPos(0).setLen(0)
+	}
+	p.counters.Misses++
+	p.l.Unlock()
+	return p.allocate(1 << (class + slabMinShift))
+}
+
+// put: put b back, it is freed if the pool is full, its size is not a class or the memory is pressured.
+func (p *slabPool) put(b *buffer) {
+	if b == nil || b.entity == nil {
+		return
+	}
+
+	size := len(b.shadow)
+	class := slabClass(size)
+	p.l.Lock()
+	if class < 0 || size != 1<<(class+slabMinShift) || p.counters.Free+size > p.limit || memory.pressure() {
+		p.counters.Drops++
+		p.l.Unlock()
+		b.free()
+		return
+	}
+	p.free[class] = append(p.free[class], b)
+	p.counters.Puts++
+	p.counters.Free += size
+	p.l.Unlock()
+}
+
+func (p *slabPool) stats() PoolStats {
+	p.l.Lock()
+	defer p.l.Unlock()
+	stats := p.counters
+	stats.HugeFailures = uscall.HugeFailures()
+	return stats
+}
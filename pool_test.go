@@ -0,0 +1,68 @@
+package usnet
+
+import (
+	"testing"
+	"usnet/uscall"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlabClass(t *testing.T) {
+	assert.Equal(t, 0, slabClass(1))
+	assert.Equal(t, 0, slabClass(1024))
+	assert.Equal(t, 1, slabClass(1025))
+	assert.Equal(t, 3, slabClass(connBufferSize))
+	assert.Equal(t, slabClasses-1, slabClass(64<<10))
+	assert.Equal(t, -1, slabClass(64<<10+1))
+}
+
+func TestSlabPool(t *testing.T) {
+	p := newSlabPool(3 * connBufferSize)
+
+	// the size is rounded up to the class.
+	b := p.get(connBufferSize - 1)
+	assert.Equal(t, connBufferSize, len(b.shadow))
+	b.Append([]byte("data_xxxx"))
+	p.put(b)
+	assert.Equal(t, PoolStats{Misses: 1, Puts: 1, Free: connBufferSize}, p.stats())
+
+	// the free buffer is taken and reset.
+	assert.Same(t, b, p.get(connBufferSize))
+	assert.Equal(t, 0, b.Len())
+	assert.Equal(t, PoolStats{Hits: 1, Misses: 1, Puts: 1}, p.stats())
+
+	// the buffers over the limit or out of the classes are freed.
+	bufs := []*buffer{b, p.get(connBufferSize), p.get(connBufferSize), p.get(connBufferSize)}
+	for _, b := range bufs {
+		p.put(b)
+	}
+	assert.Nil(t, bufs[3].entity)
+	large := p.get(128 << 10)
+	assert.Equal(t, 128<<10, len(large.shadow))
+	p.put(large)
+	assert.Nil(t, large.entity)
+	assert.Equal(t, PoolStats{Hits: 1, Misses: 5, Puts: 4, Drops: 2, Free: 3 * connBufferSize}, p.stats())
+
+	// nothing is kept without limit.
+	p = newSlabPool(0)
+	p.put(p.get(connBufferSize))
+	assert.Equal(t, PoolStats{Misses: 1, Drops: 1}, p.stats())
+}
+
+func TestSlabPoolHugepage(t *testing.T) {
+	p := newSlabPool(defaultPoolLimit)
+	p.alloc, p.node = uscall.AllocHugepage, uscall.NumaNode()
+
+	// it falls back to malloc if no hugepage is reserved.
+	b := p.get(connBufferSize)
+	assert.Equal(t, connBufferSize, len(b.shadow))
+	b.Append([]byte("data_xxxx"))
+	assert.Equal(t, []byte("data_xxxx"), b.Data())
+	stats := p.stats()
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.LessOrEqual(t, stats.Fallbacks, uint64(1))
+
+	p.put(b)
+	assert.Equal(t, b, p.get(connBufferSize))
+	b.free()
+}
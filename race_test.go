@@ -0,0 +1,71 @@
+//go:build syscall
+// +build syscall
+
+package usnet
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConnStress: Read, Write, SetDeadline and Close concurrently,
+// run with `go test -race -tags syscall -run TestConnStress`.
+func TestConnStress(t *testing.T) {
+	for n := 0; n < 8; n++ {
+		client := testDail(t)
+		conn := testNewConn(testAccept(t))
+		go io.Copy(client, client) // echo
+
+		var closed atomic.Bool
+		var wg sync.WaitGroup
+		loop := func(op func() error) {
+			defer wg.Done()
+			for {
+				if err := op(); errors.Is(err, net.ErrClosed) {
+					return
+				} else if err != nil && !errors.Is(err, os.ErrDeadlineExceeded) {
+					t.Errorf("unexpected error: %v", err)
+					return
+				} else if err != nil && closed.Load() {
+					return
+				}
+			}
+		}
+
+		wg.Add(4)
+		go loop(func() error {
+			_, err := conn.Read(make([]byte, 1024))
+			return err
+		})
+		go loop(func() error {
+			_, err := conn.Write([]byte("data_xxxx"))
+			return err
+		})
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if err := conn.SetDeadline(time.Now().Add(time.Duration(i%3) * time.Millisecond)); err != nil {
+					assert.ErrorIs(t, err, net.ErrClosed)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			time.Sleep(50 * time.Millisecond)
+			assert.NoError(t, conn.Close())
+			closed.Store(true)
+		}()
+
+		wg.Wait()
+		client.Close()
+	}
+}
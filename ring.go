@@ -0,0 +1,167 @@
+package usnet
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const irqRingSize = 4096
+
+type irqSlot struct {
+	seq atomic.Uint64
+	v   *irq
+}
+
+/*
+irqRing is the bounded multi-producer single-consumer ring between the goroutines and the controller thread.
+
+	Every slot has a sequence like the bounded queue of D. Vyukov: the slot is free for the push at pos
+	when its sequence is pos, and it is ready for the pop when its sequence is pos+1.
+	The producers reserve the slot by CAS on tail, the consumer owns head without atomic.
+	The push never blocks: while the ring is full, the irqs are appended to the overflow
+	list under the lock, and the following pushes go there until it is popped empty, so
+	the irqs of one producer stay in order. The producer may hold the lock of fd, and the
+	controller pushes into its own ring, neither could wait for the consumer.
+
+	The idle consumer parks on the wake channel, the producer unparks it only if it is parked,
+	so the busy loop is not signaled by every push. The consumer blocked in the wait of poller is
+	kicked the same way, by the eventfd in the wait.
+*/
+type irqRing struct {
+	_    [64]byte
+	tail atomic.Uint64 // the next pos to push
+	_    [56]byte
+	head uint64 // the next pos to pop, owned by the consumer
+	_    [56]byte
+
+	parked  atomic.Bool
+	blocked atomic.Bool // the consumer is blocked in the wait of poller
+	wake    chan struct{}
+	kick    func() // wake the blocked consumer, nil if its wait could not be woken
+	mask    uint64
+	slots   []irqSlot
+
+	ol       sync.Mutex
+	overflow []*irq       // pushed while the ring is full, popped after the ring
+	spilled  atomic.Int64 // the length of overflow
+}
+
+// newIrqRing: the size is rounded up to the power of 2.
+func newIrqRing(size int) *irqRing {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+
+	r := &irqRing{
+		wake:  make(chan struct{}, 1),
+		mask:  uint64(n - 1),
+		slots: make([]irqSlot, n),
+	}
+	for i := range r.slots {
+		r.slots[i].seq.Store(uint64(i))
+	}
+	return r
+}
+
+// push: called by any goroutine, it never blocks.
+func (r *irqRing) push(v *irq) {
+	for r.spilled.Load() == 0 {
+		pos := r.tail.Load()
+		s := &r.slots[pos&r.mask]
+		if seq := s.seq.Load(); seq == pos {
+			if r.tail.CompareAndSwap(pos, pos+1) {
+				s.v = v
+				s.seq.Store(pos + 1) // publish
+				r.unpark()
+				return
+			}
+		} else if seq < pos { // full, the slot has not been popped
+			break
+		}
+		// the slot is reserved by another producer, try the next one.
+	}
+
+	r.ol.Lock()
+	r.overflow = append(r.overflow, v)
+	r.spilled.Add(1)
+	r.ol.Unlock()
+	r.unpark()
+}
+
+// pop: called by the consumer only, return nil if the ring and overflow are empty.
+func (r *irqRing) pop() *irq {
+	s := &r.slots[r.head&r.mask]
+	if s.seq.Load() != r.head+1 {
+		return r.unspill()
+	}
+
+	v := s.v
+	s.v = nil
+	s.seq.Store(r.head + r.mask + 1) // free the slot for the next round
+	r.head++
+	return v
+}
+
+// unspill: pop the oldest irq of overflow, nil if it is empty.
+func (r *irqRing) unspill() *irq {
+	if r.spilled.Load() == 0 {
+		return nil
+	}
+
+	r.ol.Lock()
+	defer r.ol.Unlock()
+	v := r.overflow[0]
+	r.overflow[0] = nil
+	if r.overflow = r.overflow[1:]; len(r.overflow) == 0 {
+		r.overflow = nil
+	}
+	r.spilled.Add(-1)
+	return v
+}
+
+// empty: called by the consumer only.
+func (r *irqRing) empty() bool {
+	return r.slots[r.head&r.mask].seq.Load() != r.head+1 && r.spilled.Load() == 0
+}
+
+// park: block the idle consumer until the next push.
+func (r *irqRing) park() {
+	r.parked.Store(true)
+	if r.empty() { // check it again, the push before parked is not unparking.
+		<-r.wake
+	}
+	r.parked.Store(false)
+}
+
+func (r *irqRing) unpark() {
+	if r.parked.Load() && r.parked.CompareAndSwap(true, false) {
+		select {
+		case r.wake <- struct{}{}:
+		default: // the consumer has been woken
+		}
+	}
+	if r.blocked.Load() && r.blocked.CompareAndSwap(true, false) {
+		r.kick()
+	}
+}
+
+// block: the consumer is going to block in the wait of poller, which is kicked by the next push.
+// Return false if the ring is not empty, the consumer should poll instead.
+func (r *irqRing) block() bool {
+	if r.kick == nil { // the wait times out only
+		return true
+	}
+
+	r.blocked.Store(true)
+	if !r.empty() { // check it again, the push before blocked is not kicking.
+		r.blocked.Store(false)
+		return false
+	}
+	return true
+}
+
+// unblock: the wait of consumer returns.
+func (r *irqRing) unblock() {
+	r.blocked.Store(false)
+}
@@ -0,0 +1,230 @@
+package usnet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIrqRing(t *testing.T) {
+	r := newIrqRing(3)
+	assert.Equal(t, 4, len(r.slots))
+	assert.Nil(t, r.pop())
+
+	// FIFO over several rounds of the ring.
+	irqs := make([]*irq, 10)
+	for i := range irqs {
+		irqs[i] = &irq{}
+	}
+	for round := 0; round < 3; round++ {
+		for _, i := range irqs[:4] {
+			r.push(i)
+		}
+		for _, i := range irqs[:4] {
+			assert.Equal(t, i, r.pop())
+		}
+		assert.True(t, r.empty())
+	}
+
+	// the pushes over the full ring go to the overflow, still in order.
+	for _, i := range irqs {
+		r.push(i)
+	}
+	assert.Equal(t, int64(6), r.spilled.Load())
+	r.push(irqs[0])
+	for _, i := range append(irqs, irqs[0]) {
+		assert.False(t, r.empty())
+		assert.Equal(t, i, r.pop())
+	}
+	assert.True(t, r.empty())
+	assert.Nil(t, r.pop())
+}
+
+func TestIrqRingOverflow(t *testing.T) {
+	r, ih := newIrqRing(4), newIrqHandler()
+
+	// the consumer completes the irqs with the lock of fd held by the producer, which is
+	// not blocked by the full ring.
+	n, done := 64, make(chan struct{})
+	go func() {
+		for i := 0; i < n; i++ {
+			for v := r.pop(); ; v = r.pop() {
+				if v != nil {
+					ih.Lock()
+					v.n = i
+					ih.Unlock()
+					break
+				}
+				r.park()
+			}
+		}
+		close(done)
+	}()
+
+	irqs := make([]*irq, n)
+	ih.Lock()
+	for i := range irqs {
+		irqs[i] = &irq{n: -1}
+		r.push(irqs[i])
+	}
+	ih.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the consumer is blocked")
+	}
+	for i, iReq := range irqs {
+		assert.Equal(t, i, iReq.n)
+	}
+}
+
+func TestIrqRingPark(t *testing.T) {
+	r := newIrqRing(16)
+	iReq := &irq{}
+
+	// not parked if the ring is not empty.
+	r.push(iReq)
+	r.park()
+	assert.Equal(t, iReq, r.pop())
+
+	// the parked consumer is woken by push.
+	woken := make(chan *irq)
+	go func() {
+		r.park()
+		woken <- r.pop()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.push(iReq)
+	assert.Equal(t, iReq, <-woken)
+
+	// the blocked consumer is kicked once by push, not if the ring is not empty.
+	kicks := 0
+	r.kick = func() { kicks++ }
+	assert.True(t, r.block())
+	r.push(iReq)
+	r.push(iReq)
+	assert.Equal(t, 1, kicks)
+	assert.False(t, r.block())
+	r.unblock()
+	r.pop()
+	r.pop()
+	assert.True(t, r.block())
+	r.unblock()
+	r.push(iReq)
+	assert.Equal(t, 1, kicks)
+}
+
+func TestIrqRingMPSC(t *testing.T) {
+	r := newIrqRing(64)
+	producers, n := 8, 10000
+
+	irqs := make([][]irq, producers)
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for p := range irqs {
+		irqs[p] = make([]irq, n)
+		go func(p int) {
+			defer wg.Done()
+			for i := range irqs[p] {
+				irqs[p][i].sig = INT_SIGNAL(p)
+				irqs[p][i].seq = int64(i)
+				r.push(&irqs[p][i])
+			}
+		}(p)
+	}
+
+	// every irq is popped once, in the order of its producer.
+	next := make([]int64, producers)
+	for count := 0; count < producers*n; count++ {
+		v := r.pop()
+		for ; v == nil; v = r.pop() {
+			r.park()
+		}
+		if !assert.Equal(t, next[v.sig], v.seq) {
+			break
+		}
+		next[v.sig]++
+	}
+	wg.Wait()
+	assert.Nil(t, r.pop())
+}
+
+// lockQueue is the mutex protected queue with a signal channel, as the structure.Queue replaced by irqRing.
+type lockQueue struct {
+	l      sync.Mutex
+	items  []*irq
+	single chan struct{}
+}
+
+func (q *lockQueue) push(v *irq) {
+	q.l.Lock()
+	q.items = append(q.items, v)
+	q.l.Unlock()
+	select {
+	case q.single <- struct{}{}:
+	default:
+	}
+}
+
+func (q *lockQueue) pop() *irq {
+	q.l.Lock()
+	defer q.l.Unlock()
+	if len(q.items) == 0 {
+		return nil
+	}
+	v := q.items[0]
+	q.items = q.items[1:]
+	return v
+}
+
+// BenchmarkIrqRing: the producers push in parallel, a consumer pops and parks when idle.
+func BenchmarkIrqRing(b *testing.B) {
+	iReq := &irq{}
+
+	b.Run("ring", func(b *testing.B) {
+		r, stop := newIrqRing(irqRingSize), make(chan struct{})
+		go func() {
+			for {
+				for v := r.pop(); v != nil; v = r.pop() {
+				}
+				select {
+				case <-stop:
+					return
+				default:
+					r.park()
+				}
+			}
+		}()
+		b.RunParallel(func(p *testing.PB) {
+			for p.Next() {
+				r.push(iReq)
+			}
+		})
+		close(stop)
+		r.push(iReq) // unpark
+	})
+
+	b.Run("lock", func(b *testing.B) {
+		q, stop := &lockQueue{single: make(chan struct{}, 1)}, make(chan struct{})
+		go func() {
+			for {
+				for v := q.pop(); v != nil; v = q.pop() {
+				}
+				select {
+				case <-stop:
+					return
+				case <-q.single:
+				}
+			}
+		}()
+		b.RunParallel(func(p *testing.PB) {
+			for p.Next() {
+				q.push(iReq)
+			}
+		})
+		close(stop)
+	})
+}
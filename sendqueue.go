@@ -0,0 +1,174 @@
+package usnet
+
+import (
+	"errors"
+	"sync"
+)
+
+const defaultHighWater = 1 << 20 // the bytes queued by default
+
+// ErrQueueFull: the send queue is over the high-water mark, returned by Write if SendQueue.NonBlock.
+var ErrQueueFull = errors.New("send queue is full")
+
+/*
+SendQueue is the asynchronous writes of connections, see WithSendQueue.
+
+	Write copies the data into the send queue of connection and returns at once, one irq
+	writes the queue on the controller whenever the fd is writable until it is empty, so the
+	small writes share the handoffs. Over HighWater bytes queued, Write blocks until the queue
+	drains, or fails with ErrQueueFull if NonBlock, a write larger than HighWater is queued
+	once the queue is empty. Flush waits for the queue written.
+	The error of writing the queue, the write deadline included, drops the data queued and
+	is returned by the next Write and Flush, the connection is broken. The write deadline
+	binds to the data queued, even if it is set after Write returns and nobody flushes. Close drops the data
+	not written, Flush before it.
+*/
+type SendQueue struct {
+	HighWater int  // the bytes queued at most, 1 MB if not positive
+	NonBlock  bool // Write fails with ErrQueueFull instead of blocking
+}
+
+// normalize: fill the defaults, nil if the writes are synchronous.
+func (q *SendQueue) normalize() *SendQueue {
+	if q == nil {
+		return nil
+	}
+	n := *q
+	if n.HighWater <= 0 {
+		n.HighWater = defaultHighWater
+	}
+	return &n
+}
+
+/*
+sendQueue is the data queued by Write in the buffers taken from pool.
+
+	The writers append to the tail buffer, the controller writes the head one, both with
+	the lock, and the data is written outside the lock. The writers never tidy the buffers,
+	so the data being written is not moved. The buffers are put back once the queue drains.
+*/
+type sendQueue struct {
+	l        sync.Mutex
+	bufs     []*buffer
+	queued   int           // the bytes not written
+	draining bool          // the irq writing the queue is submitted
+	err      error         // the error of writing, the queue is broken
+	wait     chan struct{} // closed when the queue drains, nil if nobody waits
+	notify   func(*irq)    // the notify of the irq writing the queue
+	pool     *slabPool     // nil if the buffers are not pooled
+	*SendQueue
+}
+
+// push: append b into the tail buffers, it is called with the lock.
+func (q *sendQueue) push(b []byte) {
+	for q.queued += len(b); len(b) > 0; {
+		if n := len(q.bufs); n > 0 && q.bufs[n-1].Space() > 0 {
+			b = b[q.bufs[n-1].append(b):]
+			continue
+		}
+		q.bufs = append(q.bufs, q.get())
+	}
+}
+
+// head: the data to be written, nil if the queue is empty. It is called with the lock.
+func (q *sendQueue) head() *buffer {
+	if len(q.bufs) == 0 || q.bufs[0].Len() == 0 {
+		return nil
+	}
+	return q.bufs[0]
+}
+
+// pop: consume n bytes written from the head buffer, the last one is kept for the writers
+// until the queue drains. It is called with the lock.
+func (q *sendQueue) pop(n int) {
+	q.queued -= n
+	if head := q.bufs[0]; head.discard(n) > 0 && head.Len() == 0 && len(q.bufs) > 1 {
+		q.put(head)
+		q.bufs[0] = nil
+		q.bufs = q.bufs[1:]
+	}
+}
+
+// fail: break the queue by err and drop the data, it is called with the lock.
+func (q *sendQueue) fail(err error) {
+	if q.err == nil {
+		q.err = err
+	}
+	q.queued, q.draining = 0, false
+	q.release()
+	q.signal()
+}
+
+// abort: break the queue by err while the data is being written, fail drops it once the
+// writing is done.
+func (q *sendQueue) abort(err error) {
+	q.l.Lock()
+	defer q.l.Unlock()
+	if q.err == nil {
+		q.err = err
+	}
+	q.signal()
+}
+
+// drained: the queue is written, the irq writing it is done. It is called with the lock.
+func (q *sendQueue) drained() {
+	q.draining = false
+	q.release()
+}
+
+// sent: consume n bytes written on io_uring, return true if the rest is left to write.
+func (q *sendQueue) sent(n int) bool {
+	q.l.Lock()
+	defer q.l.Unlock()
+	defer q.signal() // the writers waiting the space and flushers
+
+	if q.pop(n); q.head() != nil {
+		return true
+	}
+	q.drained()
+	return false
+}
+
+// release: put the buffers back unless the irq writing the queue holds them, it is called
+// with the lock.
+func (q *sendQueue) release() {
+	if q.draining {
+		return
+	}
+	for i, b := range q.bufs {
+		q.put(b)
+		q.bufs[i] = nil
+	}
+	q.bufs = q.bufs[:0]
+}
+
+// signal: wake the writers and flushers waiting, it is called with the lock.
+func (q *sendQueue) signal() {
+	if q.wait != nil {
+		close(q.wait)
+		q.wait = nil
+	}
+}
+
+// waiter: the channel closed by the next signal, it is called with the lock.
+func (q *sendQueue) waiter() <-chan struct{} {
+	if q.wait == nil {
+		q.wait = make(chan struct{})
+	}
+	return q.wait
+}
+
+func (q *sendQueue) get() *buffer {
+	if q.pool != nil {
+		return q.pool.get(connBufferSize)
+	}
+	return newBuffer(connBufferSize)
+}
+
+func (q *sendQueue) put(b *buffer) {
+	if q.pool != nil {
+		q.pool.put(b)
+	} else {
+		b.free()
+	}
+}
@@ -0,0 +1,224 @@
+package usnet
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"usnet/uscall"
+)
+
+// ErrCanceled: the op submitted is canceled by Op.Cancel.
+var ErrCanceled = errors.New("operation was canceled")
+
+/*
+Op is the read or write submitted by SubmitRead and SubmitWrite, it completes once.
+
+	The op is served by the controller without a goroutine waiting, its completion is
+	reported by the callback and Done. The callbacks run on the completion goroutine of
+	the controller in the order the ops complete, they must not block, the long work is
+	handed off. On io_uring the op is submitted as an sqe on the buffer pinned.
+	The buffer belongs to the op until it completes. The deadlines of connection apply
+	to the pending ops, Close completes them with net.ErrClosed.
+*/
+type Op struct {
+	c    *conn
+	iReq *irq          // nil if the op completes without the controller
+	iov  *uscall.IOVec // the buffer pinned for the controller
+	b    []byte
+	mode int
+	n    int   // the bytes transferred, the written ones are counted by the controller
+	err  error // wrapped like Read and Write
+	fn   func(n int, err error)
+	done chan struct{}
+}
+
+// Done returns the channel closed once the op completes, after its callback returns.
+func (op *Op) Done() <-chan struct{} {
+	return op.done
+}
+
+// Result returns the bytes transferred and the error, it is valid after Done is closed.
+func (op *Op) Result() (int, error) {
+	return op.n, op.err
+}
+
+// Cancel completes the pending op with ErrCanceled, it returns false if the op is
+// running or completed. On io_uring the sqe is cancelled, the op completes once it is
+// reaped, with the bytes transferred meanwhile if any.
+func (op *Op) Cancel() bool {
+	if op.iReq == nil {
+		return false
+	}
+
+	canceled := false
+	m := errorWrapMf(matchFunc{iReq: op.iReq, match: func(i *irq) bool {
+		canceled = i.claim()
+		return canceled
+	}}, ErrCanceled)
+	op.c.fd.irqHandler.interrupt(INT_SRC_NONE, m, false)
+	return canceled
+}
+
+// SubmitRead reads into b like Read and returns at once, fn is called with the result
+// if it is not nil, see Op. The data buffered by Read is completed first without the io,
+// so the reads should not be mixed.
+func (c *conn) SubmitRead(b []byte, fn func(n int, err error)) *Op {
+	op := c.newOp('r', b, fn)
+	if err := c.prepare('r'); err != nil || len(b) == 0 {
+		return op.complete(0, err)
+	}
+
+	ctx := &c.rCtx
+	ctx.l.Lock()
+	if ctx.Len() > 0 {
+		n := ctx.Read(b)
+		ctx.l.Unlock()
+		return op.complete(n, nil)
+	}
+	ctx.l.Unlock()
+	return c.submitOp(op, INT_SIG_INPUT)
+}
+
+// SubmitWrite writes all of b like Write and returns at once, fn is called with the
+// result if it is not nil, see Op. The ops are written in the order submitted, the data
+// of Write and the send queue is not ordered with them.
+func (c *conn) SubmitWrite(b []byte, fn func(n int, err error)) *Op {
+	op := c.newOp('w', b, fn)
+	if err := c.prepare('w'); err != nil || len(b) == 0 {
+		return op.complete(0, err)
+	}
+	return c.submitOp(op, INT_SIG_OUTPUT)
+}
+
+// newOp: the op is counted in the refs of fd until it completes, so the deadline applies.
+func (c *conn) newOp(mode int, b []byte, fn func(n int, err error)) *Op {
+	c.fd.incref(mode)
+	return &Op{c: c, b: b, mode: mode, fn: fn, done: make(chan struct{})}
+}
+
+// submitOp: serve the op by the controller, its irq is owned by the op and never put back,
+// so Cancel could match it whenever.
+func (c *conn) submitOp(op *Op, sig INT_SIGNAL) *Op {
+	op.iov = uscall.NewIOVec(1)
+	op.iReq = newIrq((*connHandler)(c), c.fd, sig)
+	op.iReq.any = op
+	atomic.AddUint64(&c.ops, 1)
+	c.fd.submit(op.iReq, op.mode, op.notify, c.utrl)
+	return op
+}
+
+// notify: the irq of op is done, it is called with the lock of irqHandler.
+func (op *Op) notify(iReq *irq) {
+	n := iReq.n
+	if op.mode == 'w' {
+		n = op.n
+	}
+	op.complete(n, iReq.err)
+}
+
+// complete: save the result and queue the op to the completion goroutine of controller.
+func (op *Op) complete(n int, err error) *Op {
+	name := "read"
+	if op.mode == 'w' {
+		name = "write"
+	}
+	op.n, op.err = n, op.c.opError(name, err)
+	op.c.utrl.Complete(op)
+	return op
+}
+
+// finish: run on the completion goroutine, the buffer is unpinned before the callback.
+func (op *Op) finish() {
+	if op.iov != nil {
+		op.iov.Reset()
+	}
+	op.c.decref(op.mode)
+	if op.fn != nil {
+		op.fn(op.n, op.err)
+	}
+	close(op.done)
+}
+
+// transfer: read into the buffer of op once, or write it until all is written.
+func (c *connHandler) transfer(op *Op) (int, error) {
+	if op.mode == 'r' {
+		op.iov.Reset()
+		op.iov.Add(op.b)
+		return c.fd.readResult(uscall.UscallReadv(c.fd.fd, op.iov))
+	}
+
+	for op.n < len(op.b) {
+		op.iov.Reset()
+		op.iov.Add(op.b[op.n:])
+		nwrite, err := c.fd.writeResult(uscall.UscallWritev(c.fd.fd, op.iov))
+		if err != nil { // the irq waits for the writable fd on EAGAIN
+			return op.n, err
+		}
+		op.n += nwrite
+	}
+	return op.n, nil
+}
+
+// transferred: count n bytes written by the sqe of op, return true if the rest is left.
+func (op *Op) transferred(n int) bool {
+	op.n += n
+	return op.n < len(op.b)
+}
+
+// Complete: queue the op completed to the completion goroutine of controller.
+func (c *uscallController) Complete(op *Op) {
+	c.completions.push(op)
+}
+
+/*
+completer runs the callbacks of the ops completed on a controller on one goroutine.
+
+	The ops are completed with the lock of irqHandler on the controller, the timer and
+	the canceller, so the callbacks, which could submit the next op, run elsewhere.
+	The goroutine is started by the first op, it lives as long as the controller.
+*/
+type completer struct {
+	l     sync.Mutex
+	ops   []*Op
+	spare []*Op
+	ready chan struct{}
+	once  sync.Once
+}
+
+func (cq *completer) push(op *Op) {
+	cq.once.Do(func() {
+		cq.ready = make(chan struct{}, 1)
+		go cq.run()
+	})
+
+	cq.l.Lock()
+	cq.ops = append(cq.ops, op)
+	cq.l.Unlock()
+
+	select {
+	case cq.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (cq *completer) run() {
+	for range cq.ready {
+		for {
+			cq.l.Lock()
+			ops := cq.ops
+			cq.ops, cq.spare = cq.spare[:0], nil
+			cq.l.Unlock()
+			if len(ops) == 0 {
+				break
+			}
+
+			for i, op := range ops {
+				op.finish()
+				ops[i] = nil
+			}
+			cq.l.Lock()
+			cq.spare = ops
+			cq.l.Unlock()
+		}
+	}
+}
@@ -0,0 +1,122 @@
+//go:build syscall || netstack
+// +build syscall netstack
+
+package usnet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnSubmit(t *testing.T) {
+	client := testDail(t)
+	defer client.Close()
+	conn := testNewConn(testAccept(t))
+	defer conn.Close()
+
+	// the read completes by the callback.
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	b := make([]byte, 16)
+	op := conn.SubmitRead(b, func(n int, err error) {
+		done <- result{n, err}
+	})
+	_, err := client.Write([]byte("data"))
+	assert.NoError(t, err)
+	r := <-done
+	assert.NoError(t, r.err)
+	assert.Equal(t, []byte("data"), b[:r.n])
+	<-op.Done()
+	n, err := op.Result()
+	assert.Equal(t, 4, n)
+	assert.NoError(t, err)
+
+	// the write completes once all is written.
+	data := bytes.Repeat([]byte("data_xxxx"), 100000)
+	op = conn.SubmitWrite(data, nil)
+	output := make([]byte, len(data))
+	_, err = io.ReadFull(client, output)
+	assert.NoError(t, err)
+	assert.Equal(t, data, output)
+	<-op.Done()
+	n, err = op.Result()
+	assert.Equal(t, len(data), n)
+	assert.NoError(t, err)
+
+	// the deadline applies to the pending op.
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	op = conn.SubmitRead(b, nil)
+	<-op.Done()
+	_, err = op.Result()
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	conn.SetReadDeadline(time.Time{})
+
+	// the pending op is canceled, the completed one is not.
+	op = conn.SubmitRead(b, nil)
+	assert.True(t, op.Cancel())
+	<-op.Done()
+	_, err = op.Result()
+	assert.ErrorIs(t, err, ErrCanceled)
+	assert.False(t, op.Cancel())
+
+	// Close completes the pending op.
+	op = conn.SubmitRead(b, nil)
+	conn.Close()
+	<-op.Done()
+	_, err = op.Result()
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestListenSubmit(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", addr, port+12)
+	l, err := Listen("tcp", address)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	client, err := testDialer("tcp", address)
+	assert.NoError(t, err)
+	defer client.Close()
+	c, err := l.Accept()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// the callbacks echo the data until EOF without a goroutine per connection.
+	conn, closed := c.(*TCPConn), make(chan error, 1)
+	b := make([]byte, 1024)
+	var echo func(n int, err error)
+	echo = func(n int, err error) {
+		if err != nil {
+			closed <- err
+			return
+		}
+		conn.SubmitWrite(b[:n], func(_ int, err error) {
+			if err != nil {
+				closed <- err
+				return
+			}
+			conn.SubmitRead(b, echo)
+		})
+	}
+	conn.SubmitRead(b, echo)
+
+	data := bytes.Repeat([]byte("data_xxxx"), 4096)
+	go func() {
+		client.Write(data)
+		testCloseWrite(client)
+	}()
+	output := make([]byte, len(data))
+	_, err = io.ReadFull(client, output)
+	assert.NoError(t, err)
+	assert.Equal(t, data, output)
+	assert.ErrorIs(t, <-closed, io.EOF)
+}
@@ -17,11 +17,15 @@ type TCPListener struct {
 	utrl   UscallController
 	addr   *net.TCPAddr
 	poller *netpoller
+	loop   *pollLoop
+	pool   *slabPool
+	policy *BufferPolicy
+	queue  *SendQueue // nil if the writes are synchronous
 }
 
 var initOnce sync.Once
 
-func createTCPListener(addr *net.TCPAddr) (l net.Listener, err error) {
+func createTCPListener(addr *net.TCPAddr, o *options) (l net.Listener, err error) {
 	wait := make(chan struct{})
 	go func() {
 		/*f-stack use tls to store files description and don't support multi-threads posix api.
@@ -66,49 +70,101 @@ func createTCPListener(addr *net.TCPAddr) (l net.Listener, err error) {
 			return
 		}
 
+		// the bound address, the port may be chosen by stack
+		laddr, laddrLen := uscall.SockAddr{}, caddr.AddrLen()
+		if _, err = uscall.UscallGetSockName(sockfd, &laddr, &laddrLen); err != nil {
+			return
+		}
+
 		// create poller
-		if poller, err = createNetPoller(); err != nil {
+		if poller, err = createNetPoller(o.budget.Events); err != nil {
 			return
 		}
+		poller.et = o.edgeTriggered
+
+		lisfd := &fdesc{
+			fd:          sockfd,
+			irqHandler:  newIrqHandler(),
+			poller:      poller,
+			irqRegister: &irqRegister{},
+		}
+		ctrl := NewUscallController(poller)
+		ctrl.configure(o)
+		if lisfd.edge() {
+			if err = lisfd.netpoller_register(uscall.EPOLLIN, READABLE); err != nil {
+				poller.close()
+				return
+			}
+		}
+
+		// the buffers are allocated on the node of the controller thread.
+		pool := newSlabPool(o.pool)
+		pool.alloc, pool.node = o.alloc, uscall.NumaNode()
 
-		utrl = NewUscallController(poller)
+		utrl = ctrl
 		l = &TCPListener{
 			utrl:   utrl,
 			poller: poller,
-			lisfd: &fdesc{
-				fd:          sockfd,
-				irqHandler:  newIrqHandler(),
-				poller:      poller,
-				irqRegister: &irqRegister{},
-			}}
+			lisfd:  lisfd,
+			addr:   laddr.TCPAddr(),
+			loop:   &ctrl.loop,
+			pool:   pool,
+			policy: o.buffer.normalize(),
+			queue:  o.queue.normalize(),
+		}
 	}()
 
 	<-wait
 	return
 }
 
-func (l *TCPListener) create(fd int32) *TCPConn {
-	return &TCPConn{
+// create: the remote address is fetched by getpeername if raddr is nil.
+func (l *TCPListener) create(fd int32, raddr *uscall.SockAddr) *TCPConn {
+	if raddr == nil {
+		addr := uscall.SockAddr{}
+		addrLen := addr.AddrLen()
+		if _, err := uscall.UscallGetPeerName(fd, &addr, &addrLen); err == nil {
+			raddr = &addr
+		}
+	}
+
+	c := &TCPConn{
 		conn: conn{
-			rCtx: connCtx{
-				buffer: newBuffer(8192),
-			},
-			wCtx: connCtx{
-				buffer: newBuffer(8192),
-			},
 			fd: &fdesc{
 				fd:          fd,
 				poller:      l.poller,
 				irqRegister: &irqRegister{},
 				irqHandler:  newIrqHandler(),
 			},
-			utrl: l.utrl,
+			utrl:  l.utrl,
+			laddr: l.addr,
 		},
 	}
+	c.setBuffers(l.pool, l.policy)
+	c.setSendQueue(l.queue, l.pool)
+	if raddr != nil {
+		if addr := raddr.TCPAddr(); addr != nil {
+			c.raddr = addr
+		}
+	}
+	return c
+}
+
+// opError: wrap the error like the net package.
+func (l *TCPListener) opError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &net.OpError{Op: op, Net: "tcp", Addr: l.Addr(), Err: err}
 }
 
 func (l *TCPListener) accept() (*TCPConn, error) {
-	iReq := &irq{ih: &acceptHandler{TCPListener: l}, reg: l.lisfd}
+	if err := memory.wait(true, l.lisfd, 'r'); err != nil { // pause under the memory pressure
+		return nil, err
+	}
+
+	iReq := newIrq((*acceptHandler)(l), l.lisfd, INT_SIG_INPUT)
+	defer iReq.release()
 
 	l.lisfd.trap(iReq)
 	defer l.lisfd.untrap(iReq)
@@ -117,7 +173,7 @@ func (l *TCPListener) accept() (*TCPConn, error) {
 		return nil, err
 	}
 
-	l.utrl.Serve(iReq)
+	l.lisfd.serve(iReq, l.utrl)
 	if err := l.lisfd.listen(iReq); err != nil {
 		return nil, err
 	}
@@ -125,29 +181,46 @@ func (l *TCPListener) accept() (*TCPConn, error) {
 }
 
 // Accept waits for and returns the next connection to the listener.
+// The goroutines blocked in Accept are served in FIFO order.
 func (l *TCPListener) Accept() (net.Conn, error) {
-	return l.accept()
+	c, err := l.accept()
+	if err != nil {
+		return nil, l.opError("accept", err)
+	}
+	return c, nil
 }
 
 // Close closes the listener.
 // Any blocked Accept operations will be unblocked and return errors.
 func (l *TCPListener) Close() error {
-	if l.lisfd != nil {
-		l.lisfd.close()
-		l.lisfd = nil
-	}
-	if l.poller != nil {
-		l.poller.close()
-		l.poller = nil
-	}
-	return nil
+	iReq := newIrq(&closeHandler{fd: l.lisfd, poller: l.poller}, nil, INT_SIG_EXP)
+	defer iReq.release()
+
+	l.lisfd.trap(iReq)
+	defer l.lisfd.untrap(iReq)
+
+	l.lisfd.serve(iReq, l.utrl)
+	return l.opError("close", l.lisfd.listen(iReq))
 }
 
 // Addr returns the listener's network address.
 func (l *TCPListener) Addr() net.Addr {
+	if l.addr == nil {
+		return nil
+	}
 	return l.addr
 }
 
+// PollStats returns the counters of the controller loop serving the listener and its connections.
+func (l *TCPListener) PollStats() PollStats {
+	return l.loop.stats()
+}
+
+// PoolStats returns the counters of the buffer pool of the listener's connections.
+func (l *TCPListener) PoolStats() PoolStats {
+	return l.pool.stats()
+}
+
 type TCPConn struct {
 	conn
 }
@@ -157,59 +230,89 @@ func NewTCPConn(fd *fdesc, addr *uscall.SockAddr) *TCPConn {
 		conn: conn{
 			fd: fd,
 			rCtx: connCtx{
-				buffer: newBuffer(8129),
+				buffer: newBuffer(connBufferSize),
 			},
 			wCtx: connCtx{
-				buffer: newBuffer(8129),
+				buffer: newBuffer(connBufferSize),
 			},
 		},
 	}
 }
 
-type acceptHandler struct {
-	*TCPListener
-}
+// acceptHandler implement UscallHandler, converted from TCPListener without allocation.
+type acceptHandler TCPListener
 
-func (a *acceptHandler) Error(iReq *irq, err error) {
+// done: write the result of running irq and wake the waiter.
+func (a *acceptHandler) done(iReq *irq, c *TCPConn, err error) {
+	if c != nil {
+		iReq.any = c
+	}
 	iReq.err = err
 	if iReq.retry > 0 {
 		a.lisfd.netpoller_delete_event(&a.lisfd.rwaits, uscall.EPOLLIN)
 	}
-	a.lisfd.interrupt(INT_SRC_POLLER, func(i *irq) bool {
-		return i.seq == iReq.seq
-	}, false)
+	a.lisfd.interrupt(INT_SRC_POLLER, doneMf(iReq), false)
 }
 
-func (a *acceptHandler) Handle(iReq *irq) (callback bool) {
-	callback = true
-	if err := a.lisfd.isOk('r'); err != nil {
-		iReq.err = err
-	} else {
-		addr, addrLen := uscall.SockAddr{}, uint32(0)
-		if fd, err := uscall.UscallAccept(a.lisfd.fd, &addr, &addrLen); err != nil {
-			if err == syscall.EAGAIN {
-				callback = false
-			} else {
-				iReq.err = err
-			}
-		} else {
-			uscall.UscallIoctlNonBio(fd, 1)
-			iReq.any = a.create(fd)
-		}
+func (a *acceptHandler) Error(iReq *irq, err error) {
+	if iReq.begin() {
+		a.done(iReq, nil, err)
+	} else if iReq.retry > 0 {
+		a.lisfd.netpoller_delete_event(&a.lisfd.rwaits, uscall.EPOLLIN)
 	}
+}
 
-	if callback {
+func (a *acceptHandler) Handle(iReq *irq) (callback bool) {
+	if !iReq.begin() { // completed by close, drop it.
 		if iReq.retry > 0 {
 			a.lisfd.netpoller_delete_event(&a.lisfd.rwaits, uscall.EPOLLIN)
 		}
-		a.lisfd.interrupt(INT_SRC_POLLER, func(i *irq) bool {
-			return i.seq == iReq.seq
-		}, false)
-	} else {
-		if iReq.retry == 0 {
-			a.lisfd.netpoller_add_event(&a.lisfd.rwaits, uscall.EPOLLIN)
+		return true
+	}
+
+	if err := a.lisfd.isOk('r'); err != nil {
+		a.done(iReq, nil, err)
+		return true
+	} else if a.lisfd.ready(READABLE) {
+		addr := uscall.SockAddr{}
+		addrLen := addr.AddrLen()
+		fd, err := uscall.UscallAccept(a.lisfd.fd, &addr, &addrLen)
+		if err == nil {
+			c, err := a.accepted(fd, &addr)
+			a.done(iReq, c, err)
+			return true
+		} else if err != syscall.EAGAIN {
+			a.done(iReq, nil, err)
+			return true
 		}
-		iReq.retry++
+		a.lisfd.drained(READABLE)
 	}
-	return
+
+	if iReq.retry == 0 {
+		a.lisfd.netpoller_add_event(&a.lisfd.rwaits, uscall.EPOLLIN)
+	}
+	iReq.retry++
+	iReq.pause()
+
+	// the running irq is skipped by close, check it again.
+	if err := a.lisfd.isOk('r'); err != nil && iReq.begin() {
+		a.done(iReq, nil, err)
+		return true
+	}
+	return false
+}
+
+// accepted: create the connection of accepted fd.
+func (a *acceptHandler) accepted(fd int32, addr *uscall.SockAddr) (*TCPConn, error) {
+	uscall.UscallIoctlNonBio(fd, 1)
+	// watch the hangup of the idle connection, or all the events in edge-triggered mode.
+	c, event, status := (*TCPListener)(a).create(fd, addr), uscall.EPOLLRDHUP, FD_STATUS(0)
+	if c.fd.edge() {
+		event, status = event|uscall.EPOLLIN|uscall.EPOLLOUT, READABLE|WRITEABLE
+	}
+	if err := c.fd.netpoller_register(event, status); err != nil {
+		uscall.UscallClose(fd)
+		return nil, err
+	}
+	return c, nil
 }
@@ -1,9 +1,15 @@
 package usnet
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 	"usnet/uscall"
 
 	"github.com/stretchr/testify/assert"
@@ -13,7 +19,7 @@ func TestListen(t *testing.T) {
 	l, err := Listen("tcp", fmt.Sprintf("%s:%d", addr, port))
 	assert.NoError(t, err)
 	go func() {
-		client, err := net.Dial("tcp", fmt.Sprintf("%s:%d", addr, port))
+		client, err := testDialer("tcp", fmt.Sprintf("%s:%d", addr, port))
 		assert.NoError(t, err)
 		client.Close()
 	}()
@@ -22,6 +28,324 @@ func TestListen(t *testing.T) {
 	conn.Close()
 }
 
+func TestListenEdgeTriggered(t *testing.T) {
+	l, err := Listen("tcp", fmt.Sprintf("%s:%d", addr, port+1), WithEdgeTriggered())
+	assert.NoError(t, err)
+	defer l.Close()
+
+	data := []byte("data_xxxx")
+	go func() {
+		client, err := testDialer("tcp", fmt.Sprintf("%s:%d", addr, port+1))
+		assert.NoError(t, err)
+		defer client.Close()
+
+		// the first read is blocked until the edge.
+		time.Sleep(100 * time.Millisecond)
+		client.Write(data)
+		output := make([]byte, len(data))
+		_, err = io.ReadFull(client, output)
+		assert.NoError(t, err)
+		client.Write(output)
+	}()
+
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	output := make([]byte, 1024)
+	for i := 0; i < 2; i++ {
+		n, err := conn.Read(output)
+		assert.NoError(t, err)
+		assert.Equal(t, data, output[:n])
+		if i == 0 {
+			_, err = conn.Write(output[:n])
+			assert.NoError(t, err)
+		}
+	}
+
+	// EOF after the peer closed.
+	_, err = conn.Read(output)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestListenPeerClosed(t *testing.T) {
+	for i, opts := range [][]Option{nil, {WithEdgeTriggered()}} {
+		address := fmt.Sprintf("%s:%d", addr, port+2+uint(i))
+		l, err := Listen("tcp", address, opts...)
+		assert.NoError(t, err)
+
+		go func() {
+			client, err := testDialer("tcp", address)
+			assert.NoError(t, err)
+			client.Write([]byte("data_xxxx"))
+			client.Close()
+		}()
+
+		conn, err := l.Accept()
+		assert.NoError(t, err)
+
+		// the idle connection is noticed without Read.
+		assert.Eventually(t, conn.(*TCPConn).PeerClosed, time.Second, 10*time.Millisecond)
+
+		// the buffered data is still readable before EOF.
+		output, err := io.ReadAll(conn)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("data_xxxx"), output)
+
+		conn.Close()
+		l.Close()
+	}
+}
+
+func TestListenPeerReset(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", addr, port+13)
+	l, err := Listen("tcp", address)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	client, err := testDialer("tcp", address)
+	assert.NoError(t, err)
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+	tc, ok := client.(*net.TCPConn)
+	if !ok {
+		t.Skip("the client could not be reset")
+	}
+	tc.SetLinger(0) // send RST when closed
+	tc.Close()
+
+	// the reset of the idle connection is noticed, then kept for the reads and writes,
+	// the cqe of io_uring included.
+	assert.Eventually(t, conn.(*TCPConn).PeerClosed, time.Second, 10*time.Millisecond)
+	output := make([]byte, 16)
+	_, err = conn.Read(output)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	_, err = conn.Write(output)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	_, err = conn.Read(output)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+}
+
+func TestListenErrors(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", addr, port+4)
+	l, err := Listen("tcp", address)
+	assert.NoError(t, err)
+
+	client, err := testDialer("tcp", address)
+	assert.NoError(t, err)
+	defer client.Close()
+
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, client.LocalAddr().String(), conn.RemoteAddr().String())
+	assert.Equal(t, l.Addr().(*net.TCPAddr).Port, conn.LocalAddr().(*net.TCPAddr).Port)
+
+	// the deadline error is a timeout net.Error.
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	_, err = conn.Read(make([]byte, 64))
+	var opErr *net.OpError
+	if assert.ErrorAs(t, err, &opErr) {
+		assert.Equal(t, "read", opErr.Op)
+		assert.Equal(t, "tcp", opErr.Net)
+		assert.Equal(t, conn.RemoteAddr(), opErr.Addr)
+		assert.True(t, opErr.Timeout())
+	}
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// use after close.
+	assert.NoError(t, conn.Close())
+	_, err = conn.Write([]byte("data_xxxx"))
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.ErrorIs(t, conn.Close(), net.ErrClosed)
+	assert.ErrorIs(t, conn.SetDeadline(time.Time{}), net.ErrClosed)
+
+	// the blocked Accept is unblocked by Close.
+	wait := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		wait <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, l.Close())
+	err = <-wait
+	assert.ErrorIs(t, err, net.ErrClosed)
+	assert.ErrorAs(t, err, &opErr)
+	assert.ErrorIs(t, l.Close(), net.ErrClosed)
+}
+
+func TestListenPollPolicy(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", addr, port+5)
+	l, err := Listen("tcp", address, WithPollPolicy(PollPolicy{Mode: PollBlock, Spin: 10, Timeout: 10 * time.Second}))
+	assert.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		client, err := testDialer("tcp", address)
+		assert.NoError(t, err)
+		defer client.Close()
+		io.Copy(client, client) // echo
+	}()
+
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// the blocked loop is woken by the io submitted meanwhile, not by the timeout.
+	data, output := []byte("data_xxxx"), make([]byte, 9)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		time.Sleep(20 * time.Millisecond)
+		_, err = conn.Write(data)
+		assert.NoError(t, err)
+		_, err = io.ReadFull(conn, output)
+		assert.NoError(t, err)
+		assert.Equal(t, data, output)
+	}
+
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	stats := l.(*TCPListener).PollStats()
+	assert.NotZero(t, stats.Loops)
+	assert.NotZero(t, stats.Blocked)
+	assert.NotZero(t, stats.Busy)
+}
+
+func TestListenBudget(t *testing.T) {
+	// the connections make progress with the smallest budget.
+	testListenEcho(t, fmt.Sprintf("%s:%d", addr, port+6), WithBudget(Budget{Irqs: 1, Events: 1, Bytes: 1}))
+}
+
+func TestListenBatch(t *testing.T) {
+	// the io of connections is batched, the batch is flushed when full.
+	testListenEcho(t, fmt.Sprintf("%s:%d", addr, port+7), WithBatch(2))
+}
+
+func TestListenBufferPool(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", addr, port+8)
+	l, err := Listen("tcp", address, WithBufferPool(4*connBufferSize))
+	assert.NoError(t, err)
+	defer l.Close()
+
+	// the buffers of the closed connection are taken by the next one.
+	for i := 0; i < 3; i++ {
+		client, err := testDialer("tcp", address)
+		assert.NoError(t, err)
+		conn, err := l.Accept()
+		assert.NoError(t, err)
+		go client.Write([]byte("data_xxxx"))
+		output := make([]byte, 9)
+		_, err = io.ReadFull(conn, output)
+		assert.NoError(t, err)
+		assert.NoError(t, conn.Close())
+		client.Close()
+	}
+
+	stats := l.(*TCPListener).PoolStats()
+	assert.Equal(t, PoolStats{Hits: 4, Misses: 2, Puts: 6, Free: 2 * connBufferSize}, stats)
+}
+
+func TestListenBufferPolicy(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", addr, port+9)
+	policy := BufferPolicy{Min: 1024, Max: 4096, Idle: 10 * time.Millisecond}
+	l, err := Listen("tcp", address, WithEdgeTriggered(), WithBufferPolicy(policy))
+	assert.NoError(t, err)
+	defer l.Close()
+
+	client, err := testDialer("tcp", address)
+	assert.NoError(t, err)
+	defer client.Close()
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// the read waits across the idle periods without the buffer, then it is served as usual.
+	done := make(chan error)
+	output := make([]byte, 9)
+	go func() {
+		_, err := io.ReadFull(conn, output)
+		done <- err
+	}()
+	c := conn.(*TCPConn)
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&c.polling) == 1
+	}, time.Second, 5*time.Millisecond)
+	_, err = client.Write([]byte("data_xxxx"))
+	assert.NoError(t, err)
+	assert.NoError(t, <-done)
+	assert.Equal(t, []byte("data_xxxx"), output)
+
+	stats := l.(*TCPListener).PoolStats()
+	assert.Greater(t, stats.Puts, uint64(0))
+}
+
+func TestListenSendQueue(t *testing.T) {
+	address := fmt.Sprintf("%s:%d", addr, port+11)
+	l, err := Listen("tcp", address, WithSendQueue(SendQueue{HighWater: 4096}))
+	assert.NoError(t, err)
+	defer l.Close()
+
+	client, err := testDialer("tcp", address)
+	assert.NoError(t, err)
+	defer client.Close()
+	conn, err := l.Accept()
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// the writes over the high-water mark block until the queue drains.
+	data := bytes.Repeat([]byte("data_xxxx"), 4096)
+	go func() {
+		for b := data; len(b) > 0; b = b[100:] {
+			conn.Write(b[:100])
+			if len(b) < 200 {
+				conn.Write(b[100:])
+				break
+			}
+		}
+		conn.(*TCPConn).Flush()
+	}()
+	output := make([]byte, len(data))
+	_, err = io.ReadFull(client, output)
+	assert.NoError(t, err)
+	assert.Equal(t, data, output)
+}
+
+// testListenEcho: the concurrent connections echo the bulk data through the listener.
+func testListenEcho(t *testing.T, address string, opts ...Option) {
+	l, err := Listen("tcp", address, opts...)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	conns := 4
+	done := make(chan error, conns)
+	data := bytes.Repeat([]byte("data_xxxx"), 4096)
+	for i := 0; i < conns; i++ {
+		go func() {
+			client, err := testDialer("tcp", address)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer client.Close()
+			go client.Write(data)
+			output := make([]byte, len(data))
+			if _, err = io.ReadFull(client, output); err == nil && !bytes.Equal(data, output) {
+				err = io.ErrUnexpectedEOF
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < conns; i++ {
+		conn, err := l.Accept()
+		assert.NoError(t, err)
+		defer conn.Close()
+		go io.Copy(conn, conn) // echo
+	}
+	for i := 0; i < conns; i++ {
+		assert.NoError(t, <-done)
+	}
+}
+
 func TestMain(m *testing.M) {
 	uscall.UscallInit([]string{"--conf", "config.ini", "--proc-type=primary", "--proc-id=0"})
 	m.Run()
@@ -40,11 +40,12 @@ type task interface {
 
 type Item struct {
 	job
+	at    time.Time // the time of job when it is set, the job may be reused
 	index int
 }
 
 func (i *Item) set(j job) {
-	i.job = j
+	i.job, i.at = j, j.time()
 }
 
 type heapStore []*Item
@@ -61,7 +62,7 @@ func (hs heapStore) Len() int { return len(hs) }
 
 func (hs heapStore) Less(i, j int) bool {
 	// We want Pop to give us the highest, not lowest, priority so we use greater than here.
-	return hs[i].time().After(hs[j].time())
+	return hs[i].at.After(hs[j].at)
 }
 
 func (hs heapStore) Swap(i, j int) {
@@ -131,11 +132,11 @@ func (ti *timerImpl) signal() {
 func (ti *timerImpl) popJobs(now time.Time) (jobs []job) {
 	ti.l.Lock()
 	for ti.hs.root() != nil {
-		if j := ti.hs.root().job; !j.time().After(now) {
-			jobs = append(jobs, j)
+		if root := ti.hs.root(); !root.at.After(now) {
+			jobs = append(jobs, root.job)
 			heap.Pop(&ti.hs) // remove from heap
 		} else {
-			ti.ticker.Reset(j.time().Sub(now))
+			ti.ticker.Reset(root.at.Sub(now))
 			break
 		}
 	}
@@ -182,7 +183,8 @@ func (ti *timerImpl) proc() {
 func (ti *timerImpl) add(j job) task {
 	fmt.Println("add job.....")
 	ti.l.Lock()
-	tt := &Item{job: j}
+	tt := &Item{}
+	tt.set(j)
 	heap.Push(&ti.hs, tt)
 	ti.l.Unlock()
 
@@ -0,0 +1,63 @@
+package uscall
+
+/*
+#include "uscall.h"
+*/
+import "C"
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Allocator: the memory backing the CSlices.
+type Allocator int
+
+const (
+	AllocMalloc   Allocator = iota // malloc of libc, the default
+	AllocHugepage                  // the hugepages on the NUMA node, malloc if they are unavailable
+)
+
+// hugeUsed: any slice is backed by hugepages, FreeCSlice looks up the hugepages only after it.
+var hugeUsed int32
+
+// hugeFailures: the hugepages failed to allocate, see HugeFailures.
+var hugeFailures uint64
+
+// HugeFailures: the hugepages of the process failed to allocate, the node and size failed
+// fall back to malloc for a while and are retried then.
+func HugeFailures() uint64 {
+	return atomic.LoadUint64(&hugeFailures)
+}
+
+/*
+AllocCSliceOn: like AllocCSlice, but the memory is taken from a, huge reports whether it
+is backed by hugepages.
+
+	The hugepages come from rte_malloc_socket on f-stack, and from the arena of hugepages
+	mapped with MAP_HUGETLB and bound by mbind on the kernel. node is the NUMA node preferred,
+	-1 is any, see NumaNode. The slice falls back to malloc if no hugepage is available,
+	it is freed by FreeCSlice either way.
+*/
+func AllocCSliceOn(len, cap uint32, a Allocator, node int) (cs *CSlice, huge bool) {
+	if a != AllocHugepage {
+		return AllocCSlice(len, cap), false
+	} else if cap <= 0 || len > cap {
+		panic("alloc out of the memory: len > cap or cap zero.")
+	}
+
+	p := hugeAlloc(cap, node)
+	if p == nil {
+		return AllocCSlice(len, cap), false
+	}
+	atomic.StoreInt32(&hugeUsed, 1)
+	return &CSlice{ptr: (*C.char)(p), len: C.uint32_t(len), cap: C.uint32_t(cap)}, true
+}
+
+// freeHuge: free the slice backed by hugepages, return false if it is not.
+func freeHuge(cs *CSlice) bool {
+	if atomic.LoadInt32(&hugeUsed) == 0 || cs.ptr == nil || !hugeFree(unsafe.Pointer(cs.ptr)) {
+		return false
+	}
+	cs.ptr, cs.len, cs.cap = nil, 0, 0
+	return true
+}
@@ -0,0 +1,116 @@
+package uscall
+
+/*
+#include <stdlib.h>
+#include "uscall.h"
+*/
+import "C"
+import (
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// the ops of batch
+const (
+	BATCH_READ      = int32(C.BATCH_READ)
+	BATCH_WRITE     = int32(C.BATCH_WRITE)
+	BATCH_ACCEPT    = int32(C.BATCH_ACCEPT)
+	BATCH_EPOLL_CTL = int32(C.BATCH_EPOLL_CTL)
+)
+
+type BatchOp C.struct_batch_op
+
+/*
+Batch is an array of operations executed by one cgo call, see UscallBatch.
+
+	The array is allocated in C memory, the buffers of read and write must be C
+	memory too, so nothing is checked or pinned when the batch is passed to C.
+	The ops are added in order, and the result of op is fetched by its index
+	after executed, the batch is reused after Reset.
+*/
+type Batch struct {
+	ops []BatchOp
+	n   int
+}
+
+func NewBatch(size int) *Batch {
+	if size <= 0 {
+		panic("the size of batch must be positive.")
+	}
+
+	ptr := C.calloc(C.size_t(size), C.size_t(unsafe.Sizeof(BatchOp{})))
+	b := &Batch{ops: unsafe.Slice((*BatchOp)(ptr), size)}
+	runtime.SetFinalizer(b, func(b *Batch) {
+		C.free(unsafe.Pointer(&b.ops[0]))
+	})
+	return b
+}
+
+// add: return the index of op, -1 if the batch is full.
+func (b *Batch) add(op, fd int32) int {
+	if b.n == len(b.ops) {
+		return -1
+	}
+	b.ops[b.n] = BatchOp{op: C.int32_t(op), fd: C.int32_t(fd)}
+	b.n++
+	return b.n - 1
+}
+
+// Read: read output.len bytes at most into output.
+func (b *Batch) Read(fd int32, output *CSlice) int {
+	i := b.add(BATCH_READ, fd)
+	if i >= 0 {
+		b.ops[i].ptr, b.ops[i].len = output.ptr, output.len
+	}
+	return i
+}
+
+// Write: write input.len bytes at most from input.
+func (b *Batch) Write(fd int32, input *CSlice) int {
+	i := b.add(BATCH_WRITE, fd)
+	if i >= 0 {
+		b.ops[i].ptr, b.ops[i].len = input.ptr, input.len
+	}
+	return i
+}
+
+// Accept: the result is the accepted fd, the address is fetched by UscallGetPeerName.
+func (b *Batch) Accept(fd int32) int {
+	return b.add(BATCH_ACCEPT, fd)
+}
+
+func (b *Batch) EpollCtl(epfd, op, fd int32, event *Epoll_event) int {
+	i := b.add(BATCH_EPOLL_CTL, fd)
+	if i >= 0 {
+		b.ops[i].arg, b.ops[i].flags = C.int32_t(epfd), C.int32_t(op)
+		b.ops[i].events, b.ops[i].data = C.uint32_t(event.Event()), C.uint64_t(event.Data())
+	}
+	return i
+}
+
+// Result: the result of op i like the single call, -1 and the errno on failure.
+func (b *Batch) Result(i int) (int, error) {
+	if res := int64(b.ops[i].res); res < 0 {
+		return -1, syscall.Errno(-res)
+	} else {
+		return int(res), nil
+	}
+}
+
+func (b *Batch) Len() int {
+	return b.n
+}
+
+func (b *Batch) Cap() int {
+	return len(b.ops)
+}
+
+func (b *Batch) Reset() {
+	b.n = 0
+}
+
+// cops: the ops to execute.
+func (b *Batch) cops() (*C.struct_batch_op, C.int) {
+	return (*C.struct_batch_op)(&b.ops[0]), C.int(b.n)
+}
@@ -0,0 +1,103 @@
+//go:build syscall
+// +build syscall
+
+package uscall
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPipe(t testing.TB) (r, w int32) {
+	var fds [2]int
+	if err := syscall.Pipe2(fds[:], syscall.O_NONBLOCK); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+	})
+	return int32(fds[0]), int32(fds[1])
+}
+
+func TestBatch(t *testing.T) {
+	r, w := testPipe(t)
+	input, output := AllocCSlice(9, 9), AllocCSlice(64, 64)
+	defer FreeCSlice(input)
+	defer FreeCSlice(output)
+	copy(CSlice2Bytes(input), "data_xxxx")
+
+	b := NewBatch(4)
+	assert.Equal(t, 4, b.Cap())
+
+	// the ops are executed in order.
+	assert.Equal(t, 0, b.Read(r, output))
+	assert.Equal(t, 1, b.Write(w, input))
+	assert.Equal(t, 2, b.Read(r, output))
+	assert.Equal(t, 3, b.Accept(r))
+	assert.Equal(t, -1, b.Write(w, input)) // full
+	assert.Equal(t, 4, UscallBatch(b))
+
+	n, err := b.Result(0)
+	assert.Equal(t, -1, n)
+	assert.Equal(t, syscall.EAGAIN, err)
+	n, err = b.Result(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, n)
+	n, err = b.Result(2)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data_xxxx"), CSlice2Bytes(output)[:n])
+	_, err = b.Result(3)
+	assert.Equal(t, syscall.ENOTSOCK, err)
+
+	// reused after reset.
+	b.Reset()
+	assert.Equal(t, 0, UscallBatch(b))
+	epfd, err := UscallEpollCreate(0)
+	assert.NoError(t, err)
+	defer UscallClose(int32(epfd))
+	ev := (&Epoll_event{}).SetEvents(EPOLLIN).SetData(1<<32 | uint64(r))
+	assert.Equal(t, 0, b.EpollCtl(int32(epfd), EPOLL_CTL_ADD, r, ev))
+	assert.Equal(t, 1, b.Write(w, input))
+	assert.Equal(t, 2, UscallBatch(b))
+	_, err = b.Result(0)
+	assert.NoError(t, err)
+
+	events := make([]Epoll_event, 1)
+	n, err = UscallEpollWait(int32(epfd), &events[0], 1, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1<<32|uint64(r), events[0].Data())
+}
+
+// BenchmarkBatch: the writes and reads of a pipe, one cgo call per op or per batch.
+func BenchmarkBatch(b *testing.B) {
+	r, w := testPipe(b)
+	input, output := AllocCSlice(64, 64), AllocCSlice(64, 64)
+	defer FreeCSlice(input)
+	defer FreeCSlice(output)
+	ops := 16
+
+	b.Run("single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for k := 0; k < ops; k += 2 {
+				UscallWriteCSlice(w, input)
+				UscallReadCSlice(r, output)
+			}
+		}
+	})
+
+	b.Run("batch", func(b *testing.B) {
+		batch := NewBatch(ops)
+		for i := 0; i < b.N; i++ {
+			batch.Reset()
+			for k := 0; k < ops; k += 2 {
+				batch.Write(w, input)
+				batch.Read(r, output)
+			}
+			UscallBatch(batch)
+		}
+	})
+}
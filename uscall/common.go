@@ -12,6 +12,7 @@ package uscall
 */
 import "C"
 import (
+	"net"
 	"runtime/cgo"
 	"unsafe"
 )
@@ -25,6 +26,9 @@ const (
 	EPOLL_CTL_MOD = int32(C.EPOLL_CTL_MOD)
 	EPOLL_CTL_DEL = int32(C.EPOLL_CTL_DEL)
 	EPOLLERR      = uint32(C.EPOLLERR)
+	EPOLLRDHUP    = uint32(C.EPOLLRDHUP)
+	EPOLLHUP      = uint32(C.EPOLLHUP)
+	EPOLLET       = uint32(C.EPOLLET)
 )
 
 type LoopFunc func(unsafe.Pointer) int32
@@ -40,6 +44,16 @@ func (e *Epoll_event) Socket() int32 {
 	return int32(*(*C.int)(unsafe.Pointer(&e.data)))
 }
 
+// SetData: set the whole 64 bits user data, the low 32 bits are the socket on little-endian.
+func (e *Epoll_event) SetData(data uint64) *Epoll_event {
+	*(*C.uint64_t)(unsafe.Pointer(&e.data)) = C.uint64_t(data)
+	return e
+}
+
+func (e *Epoll_event) Data() uint64 {
+	return uint64(*(*C.uint64_t)(unsafe.Pointer(&e.data)))
+}
+
 func (e *Epoll_event) SetHandle(h cgo.Handle) *Epoll_event {
 	*(*cgo.Handle)(unsafe.Pointer(&e.data)) = h
 	return e
@@ -75,6 +89,23 @@ func (sa *SockAddr) SetAddr(addr string) *SockAddr {
 	return sa
 }
 
+func (sa *SockAddr) Port() int {
+	return int(C.ntohs(sa.sin_port))
+}
+
+func (sa *SockAddr) IP() net.IP {
+	ip := *(*[4]byte)(unsafe.Pointer(&sa.sin_addr.s_addr))
+	return net.IPv4(ip[0], ip[1], ip[2], ip[3])
+}
+
+// TCPAddr: return nil if the address is not an ipv4 address.
+func (sa *SockAddr) TCPAddr() *net.TCPAddr {
+	if int32(sa.sin_family) != AF_INET {
+		return nil
+	}
+	return &net.TCPAddr{IP: sa.IP(), Port: sa.Port()}
+}
+
 func (sa *SockAddr) AddrLen() uint32 {
 	return uint32(unsafe.Sizeof(*sa))
 }
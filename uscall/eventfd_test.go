@@ -0,0 +1,42 @@
+//go:build syscall || netstack
+// +build syscall netstack
+
+package uscall
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventfd(t *testing.T) {
+	UscallInit(nil)
+	epfd, err := UscallEpollCreate(0)
+	assert.NoError(t, err)
+	defer UscallClose(int32(epfd))
+	efd, err := UscallEventfd()
+	assert.NoError(t, err)
+	defer UscallClose(efd)
+
+	ev := (&Epoll_event{}).SetEvents(EPOLLIN).SetData(0)
+	_, err = UscallEpollCtl(int32(epfd), EPOLL_CTL_ADD, efd, ev)
+	assert.NoError(t, err)
+
+	// the blocked wait is woken by the write, and readable until read.
+	events := make([]Epoll_event, 1)
+	n, _ := UscallEpollWait(int32(epfd), &events[0], 1, 0)
+	assert.Equal(t, 0, n)
+	go UscallEventfdWrite(efd)
+	n, err = UscallEpollWait(int32(epfd), &events[0], 1, 5000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, UscallEventfdWrite(efd))
+	n, _ = UscallEpollWait(int32(epfd), &events[0], 1, 0)
+	assert.Equal(t, 1, n)
+
+	assert.NoError(t, UscallEventfdRead(efd))
+	n, _ = UscallEpollWait(int32(epfd), &events[0], 1, 0)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, syscall.EAGAIN, UscallEventfdRead(efd))
+}
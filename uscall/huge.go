@@ -0,0 +1,102 @@
+//go:build syscall || netstack
+// +build syscall netstack
+
+package uscall
+
+/*
+#include "uscall.h"
+*/
+import "C"
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	hugePageSize = 2 << 20 // the chunk mapped at once, one hugepage of the default size
+	hugeMinSize  = 64      // the smallest block, one cache line
+)
+
+// hugeBackoff: the node and size failed to map are skipped for it, then retried.
+var hugeBackoff = time.Second
+
+type hugeKey struct {
+	node int
+	size uint32
+}
+
+/*
+hugeArena is the hugepages of the kernel backend.
+
+	Each chunk is one hugepage mapped by sys_huge_map, it is split into the blocks of one
+	power of two size for one node. The blocks freed are reused by the same size and node,
+	the chunks are never unmapped. The chunk of a block is found by the alignment of
+	hugepages. Once the mapping fails, only the node and size failed fall back to malloc
+	until hugeBackoff passes, the blocks freed of them are still reused meanwhile.
+*/
+var hugeArena = struct {
+	l      sync.Mutex
+	free   map[hugeKey][]unsafe.Pointer
+	chunks map[uintptr]hugeKey
+	off    map[hugeKey]time.Time // the node and size failed, until the time to retry
+}{
+	free:   map[hugeKey][]unsafe.Pointer{},
+	chunks: map[uintptr]hugeKey{},
+	off:    map[hugeKey]time.Time{},
+}
+
+// hugeAlloc: take a block of size bytes at least on node, nil if no hugepage is available.
+func hugeAlloc(size uint32, node int) unsafe.Pointer {
+	if size > hugePageSize {
+		return nil
+	} else if size < hugeMinSize {
+		size = hugeMinSize
+	}
+	k := hugeKey{node: node, size: 1 << bits.Len32(size-1)}
+
+	a := &hugeArena
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	free := a.free[k]
+	if len(free) == 0 {
+		if retry, ok := a.off[k]; ok && time.Now().Before(retry) {
+			return nil
+		}
+		chunk := C.sys_huge_map(C.size_t(hugePageSize), C.int(node))
+		if chunk == nil {
+			a.off[k] = time.Now().Add(hugeBackoff)
+			atomic.AddUint64(&hugeFailures, 1)
+			return nil
+		}
+		delete(a.off, k)
+		a.chunks[uintptr(chunk)] = k
+		for off := hugePageSize - int(k.size); off >= 0; off -= int(k.size) {
+			free = append(free, unsafe.Add(chunk, off))
+		}
+	}
+	p := free[len(free)-1]
+	a.free[k] = free[:len(free)-1]
+	return p
+}
+
+// hugeFree: put the block back, return false if p is not in the hugepages.
+func hugeFree(p unsafe.Pointer) bool {
+	a := &hugeArena
+	a.l.Lock()
+	defer a.l.Unlock()
+
+	k, ok := a.chunks[uintptr(p)&^(hugePageSize-1)]
+	if ok {
+		a.free[k] = append(a.free[k], p)
+	}
+	return ok
+}
+
+// NumaNode: the NUMA node of the cpu running the calling thread, -1 if it is unknown.
+func NumaNode() int {
+	return int(C.sys_numa_node())
+}
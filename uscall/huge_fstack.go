@@ -0,0 +1,38 @@
+//go:build !syscall && !netstack
+// +build !syscall,!netstack
+
+package uscall
+
+/*
+#include <rte_malloc.h>
+#include <rte_memory.h>
+#include <rte_lcore.h>
+*/
+import "C"
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// hugeAlloc: rte_malloc_socket from the hugepages of DPDK on node, nil if the heap is exhausted.
+func hugeAlloc(size uint32, node int) unsafe.Pointer {
+	p := C.rte_malloc_socket(nil, C.size_t(size), C.RTE_CACHE_LINE_SIZE, C.int(node))
+	if p == nil {
+		atomic.AddUint64(&hugeFailures, 1)
+	}
+	return p
+}
+
+// hugeFree: rte_free p, return false if p is not in the memory of DPDK.
+func hugeFree(p unsafe.Pointer) bool {
+	if C.rte_mem_virt2memseg_list(p) == nil {
+		return false
+	}
+	C.rte_free(p)
+	return true
+}
+
+// NumaNode: the socket of the lcore running the calling thread, -1 if it is not an lcore.
+func NumaNode() int {
+	return int(int32(C.rte_socket_id()))
+}
@@ -0,0 +1,79 @@
+//go:build syscall
+// +build syscall
+
+package uscall
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocCSliceOn(t *testing.T) {
+	cs, huge := AllocCSliceOn(4, 8192, AllocMalloc, -1)
+	assert.False(t, huge)
+	FreeCSlice(cs)
+
+	node := NumaNode()
+	cs, huge = AllocCSliceOn(4, 8192, AllocHugepage, node)
+	assert.Equal(t, 4, len(CSlice2Bytes(cs)))
+	assert.Equal(t, 8192, cap(CSlice2Bytes(cs)))
+	copy(CSlice2Bytes(cs)[:8192], make([]byte, 8192)) // writable
+
+	if !huge { // no hugepage reserved, malloc
+		FreeCSlice(cs)
+		assert.Nil(t, cs.ptr)
+		t.Log("no hugepage is available")
+		return
+	}
+
+	// the blocks of one chunk are split by size, the freed one is reused.
+	other, huge := AllocCSliceOn(8000, 8000, AllocHugepage, node)
+	assert.True(t, huge)
+	chunk := func(cs *CSlice) uintptr {
+		return uintptr(unsafe.Pointer(cs.ptr)) &^ (hugePageSize - 1)
+	}
+	assert.Equal(t, chunk(cs), chunk(other))
+	ptr := unsafe.Pointer(other.ptr)
+	FreeCSlice(other)
+	assert.Nil(t, other.ptr)
+	other, _ = AllocCSliceOn(8192, 8192, AllocHugepage, node)
+	assert.Equal(t, ptr, unsafe.Pointer(other.ptr))
+	FreeCSlice(other)
+	FreeCSlice(cs)
+
+	// out of the hugepage.
+	cs, huge = AllocCSliceOn(hugePageSize+1, hugePageSize+1, AllocHugepage, node)
+	assert.False(t, huge)
+	FreeCSlice(cs)
+}
+
+func TestHugeBackoff(t *testing.T) {
+	defer func(d time.Duration) { hugeBackoff = d }(hugeBackoff)
+	hugeBackoff = time.Hour
+	hugeArena.l.Lock()
+	hugeArena.off = map[hugeKey]time.Time{}
+	hugeArena.l.Unlock()
+
+	// the node and size failed is skipped until the backoff passes, the others are still tried.
+	node := NumaNode()
+	failures := HugeFailures()
+	if p := hugeAlloc(4096, node); p != nil {
+		hugeFree(p)
+		t.Skip("the hugepages are available")
+	}
+	assert.Equal(t, failures+1, HugeFailures())
+	assert.True(t, hugeAlloc(4096, node) == nil)
+	assert.Equal(t, failures+1, HugeFailures())
+
+	assert.True(t, hugeAlloc(8192, node) == nil)
+	assert.Equal(t, failures+2, HugeFailures())
+
+	hugeArena.l.Lock()
+	hugeArena.off[hugeKey{node: node, size: 4096}] = time.Now()
+	hugeArena.l.Unlock()
+	assert.True(t, hugeAlloc(4096, node) == nil)
+	assert.Equal(t, failures+3, HugeFailures())
+}
@@ -0,0 +1,93 @@
+package uscall
+
+/*
+#include <stdlib.h>
+#include "uscall.h"
+*/
+import "C"
+import (
+	"runtime"
+	"unsafe"
+)
+
+// IOVMax: the max count of slices in IOVec.
+const IOVMax = int(C.SLICE_IOV_MAX)
+
+/*
+IOVec is an array of CSlice read into or written from by one vectored call,
+see UscallReadv and UscallWritev.
+
+	The array is allocated in C memory like Batch. The Go memory added is pinned
+	until Reset, so it could be referenced by the array passed to C, the caller must
+	not touch it before Reset. The slices are added in order.
+*/
+type IOVec struct {
+	iov    []CSlice
+	n      int
+	pinner runtime.Pinner
+	msg    unsafe.Pointer // the msghdr of io_uring, nil if it is not submitted
+}
+
+func NewIOVec(size int) *IOVec {
+	if size <= 0 || size > IOVMax {
+		panic("the size of iovec must be in (0, IOVMax].")
+	}
+
+	ptr := C.calloc(C.size_t(size), C.size_t(unsafe.Sizeof(CSlice{})))
+	v := &IOVec{iov: unsafe.Slice((*CSlice)(ptr), size)}
+	runtime.SetFinalizer(v, func(v *IOVec) {
+		v.pinner.Unpin()
+		C.free(unsafe.Pointer(&v.iov[0]))
+		C.free(v.msg)
+	})
+	return v
+}
+
+// Add: add b to the tail, the empty b is skipped, return false if the iovec is full.
+func (v *IOVec) Add(b []byte) bool {
+	if len(b) == 0 {
+		return true
+	} else if v.n == len(v.iov) {
+		return false
+	}
+
+	v.pinner.Pin(unsafe.SliceData(b)) // nothing is done for C memory
+	Bytes2CSliceTo(b, &v.iov[v.n])
+	v.n++
+	return true
+}
+
+// AddBytes: add bufs in order until the iovec is full, return the count of bufs added.
+func (v *IOVec) AddBytes(bufs [][]byte) (i int) {
+	for ; i < len(bufs) && v.Add(bufs[i]); i++ {
+	}
+	return
+}
+
+// Len: the count of slices.
+func (v *IOVec) Len() int {
+	return v.n
+}
+
+// Bytes: the total length of slices.
+func (v *IOVec) Bytes() (n int) {
+	for i := range v.iov[:v.n] {
+		n += int(v.iov[i].len)
+	}
+	return
+}
+
+func (v *IOVec) Cap() int {
+	return len(v.iov)
+}
+
+// Reset: clear the slices and unpin the Go memory.
+func (v *IOVec) Reset() {
+	v.pinner.Unpin()
+	v.n = 0
+}
+
+// cslices: the array and count passed to C.
+func (v *IOVec) cslices() (*C.struct_slice, C.int) {
+	return (*C.struct_slice)(unsafe.Pointer(&v.iov[0])), C.int(v.n)
+}
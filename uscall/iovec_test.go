@@ -0,0 +1,52 @@
+//go:build syscall
+// +build syscall
+
+package uscall
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIOVec(t *testing.T) {
+	r, w := testPipe(t)
+	input := AllocCSlice(4, 4)
+	defer FreeCSlice(input)
+	copy(CSlice2Bytes(input), "xxxx")
+
+	// the Go memory and C memory are written in order by one call.
+	v := NewIOVec(4)
+	assert.Equal(t, 4, v.Cap())
+	assert.True(t, v.Add([]byte("data")))
+	assert.True(t, v.Add(nil)) // skipped
+	assert.True(t, v.Add([]byte("_")))
+	assert.True(t, v.Add(CSlice2Bytes(input)))
+	assert.Equal(t, 3, v.Len())
+	assert.Equal(t, 9, v.Bytes())
+	n, err := UscallWritev(w, v)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, n)
+
+	v.Reset()
+	head, body := make([]byte, 5), make([]byte, 8)
+	assert.True(t, v.Add(head))
+	assert.True(t, v.Add(body))
+	n, err = UscallReadv(r, v)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, n)
+	assert.Equal(t, []byte("data_"), head)
+	assert.Equal(t, []byte("xxxx"), body[:4])
+
+	n, err = UscallReadv(r, v)
+	assert.Equal(t, -1, n)
+	assert.Equal(t, syscall.EAGAIN, err)
+	v.Reset()
+
+	// full
+	assert.Equal(t, 5, v.AddBytes([][]byte{head, nil, head, head, head, head}))
+	assert.Equal(t, v.Cap(), v.Len())
+	assert.False(t, v.Add(head))
+	v.Reset()
+}
@@ -0,0 +1,133 @@
+//go:build netstack && !syscall
+// +build netstack,!syscall
+
+package uscall
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+)
+
+/*
+The netstack backend runs the gVisor tcp/ip stack in user space.
+
+	Two stacks are joined back to back by channel link endpoints: the local stack
+	serves the uscall api, the peer stack is used by NetstackDial and NetstackListen.
+	No NIC or kernel socket is involved, so real tcp behaviour could be tested offline.
+*/
+const (
+	NetstackAddr     = "10.0.0.1" // the address of the local stack.
+	NetstackPeerAddr = "10.0.0.2" // the address of the peer stack.
+
+	netstackNIC   tcpip.NICID = 1
+	netstackMTU               = 1500
+	netstackQueue             = 1024
+)
+
+type netstack struct {
+	local, peer *stack.Stack
+}
+
+var (
+	nsOnce sync.Once
+	ns     *netstack
+)
+
+func netstackInit() *netstack {
+	nsOnce.Do(func() {
+		ns = &netstack{}
+		lep, pep := channel.New(netstackQueue, netstackMTU, ""), channel.New(netstackQueue, netstackMTU, "")
+		ns.local = newNetstack(lep, NetstackAddr)
+		ns.peer = newNetstack(pep, NetstackPeerAddr)
+
+		go netstackLink(context.Background(), lep, pep)
+		go netstackLink(context.Background(), pep, lep)
+	})
+	return ns
+}
+
+func newNetstack(ep stack.LinkEndpoint, addr string) *stack.Stack {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+
+	if err := s.CreateNIC(netstackNIC, ep); err != nil {
+		panic(err.String())
+	}
+
+	paddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: netstackAddress(addr).WithPrefix(),
+	}
+	if err := s.AddProtocolAddress(netstackNIC, paddr, stack.AddressProperties{}); err != nil {
+		panic(err.String())
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: netstackNIC}})
+	return s
+}
+
+// netstackLink: deliver the outbound packets of from to the inbound of to.
+func netstackLink(ctx context.Context, from, to *channel.Endpoint) {
+	for {
+		pkt := from.ReadContext(ctx)
+		if pkt == nil {
+			return
+		}
+
+		// the link header is not set by channel endpoint, the payload is ip packet.
+		inbound := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: pkt.ToBuffer(),
+		})
+		to.InjectInbound(pkt.NetworkProtocolNumber, inbound)
+		inbound.DecRef()
+		pkt.DecRef()
+	}
+}
+
+func netstackAddress(addr string) tcpip.Address {
+	if ip := net.ParseIP(addr).To4(); ip != nil {
+		return tcpip.AddrFrom4Slice(ip)
+	}
+	return tcpip.Address{}
+}
+
+func netstackFullAddress(address string) (tcpip.FullAddress, error) {
+	addr, err := net.ResolveTCPAddr("tcp4", address)
+	if err != nil {
+		return tcpip.FullAddress{}, err
+	}
+
+	faddr := tcpip.FullAddress{NIC: netstackNIC, Port: uint16(addr.Port)}
+	if ip := addr.IP.To4(); ip != nil && !ip.IsUnspecified() {
+		faddr.Addr = tcpip.AddrFrom4Slice(ip)
+	}
+	return faddr, nil
+}
+
+// NetstackDial: connect to the address from the peer stack, such as NetstackAddr:port.
+func NetstackDial(address string) (net.Conn, error) {
+	addr, err := netstackFullAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return gonet.DialTCP(netstackInit().peer, addr, ipv4.ProtocolNumber)
+}
+
+// NetstackListen: listen the address on the peer stack, it could be connected from the local stack.
+func NetstackListen(address string) (net.Listener, error) {
+	addr, err := netstackFullAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	return gonet.ListenTCP(netstackInit().peer, addr, ipv4.ProtocolNumber)
+}
@@ -0,0 +1,116 @@
+//go:build netstack && !syscall
+// +build netstack,!syscall
+
+package uscall
+
+import (
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testNetstackListen(t *testing.T, port uint) int32 {
+	UscallInit(nil)
+	sockfd, err := UscallSocket(AF_INET, SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, UscallSetReusePort(sockfd))
+
+	addr := (&SockAddr{}).SetFamily(AF_INET).SetPort(port).SetAddr("0.0.0.0")
+	_, err = UscallBind(sockfd, addr, addr.AddrLen())
+	assert.NoError(t, err)
+	_, err = UscallListen(sockfd, 16)
+	assert.NoError(t, err)
+	return sockfd
+}
+
+func TestNetstackEcho(t *testing.T) {
+	sockfd := testNetstackListen(t, 18091)
+	defer UscallClose(sockfd)
+
+	client, err := NetstackDial(fmt.Sprintf("%s:%d", NetstackAddr, 18091))
+	assert.NoError(t, err)
+	defer client.Close()
+
+	addr, addrLen := SockAddr{}, uint32(0)
+	fd, err := UscallAccept(sockfd, &addr, &addrLen)
+	assert.NoError(t, err)
+	assert.Equal(t, addr.AddrLen(), addrLen)
+
+	data := []byte("data_xxxx")
+	go client.Write(data)
+
+	output := make([]byte, 64)
+	n, err := UscallRead(fd, output)
+	assert.NoError(t, err)
+	assert.Equal(t, data, output[:n])
+
+	n, err = UscallWrite(fd, output[:n])
+	assert.NoError(t, err)
+	_, err = io.ReadFull(client, output[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, data, output[:n])
+
+	// EOF after the peer closed.
+	client.Close()
+	n, err = UscallRead(fd, output)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = UscallClose(fd)
+	assert.NoError(t, err)
+
+	_, err = UscallRead(fd, output)
+	assert.Equal(t, syscall.EBADF, err)
+}
+
+func TestNetstackEpoll(t *testing.T) {
+	sockfd := testNetstackListen(t, 18092)
+	defer UscallClose(sockfd)
+	UscallIoctlNonBio(sockfd, 1)
+
+	epfd, err := UscallEpollCreate(0)
+	assert.NoError(t, err)
+	defer UscallClose(int32(epfd))
+
+	ev := (&Epoll_event{}).SetEvents(EPOLLIN).SetSocket(sockfd)
+	_, err = UscallEpollCtl(int32(epfd), EPOLL_CTL_ADD, sockfd, ev)
+	assert.NoError(t, err)
+	_, err = UscallEpollCtl(int32(epfd), EPOLL_CTL_ADD, sockfd, ev)
+	assert.Equal(t, syscall.EEXIST, err)
+
+	events := make([]Epoll_event, 8)
+	n, err := UscallEpollWait(int32(epfd), &events[0], 8, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = UscallAccept(sockfd, nil, nil)
+	assert.Equal(t, syscall.EAGAIN, err)
+
+	client, err := NetstackDial(fmt.Sprintf("%s:%d", NetstackAddr, 18092))
+	assert.NoError(t, err)
+	defer client.Close()
+
+	// level-triggered: report again until accepted.
+	for i := 0; i < 2; i++ {
+		n, err = UscallEpollWait(int32(epfd), &events[0], 8, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, n)
+		assert.Equal(t, sockfd, events[0].Socket())
+		assert.NotZero(t, events[0].Event()&EPOLLIN)
+	}
+
+	fd, err := UscallAccept(sockfd, nil, nil)
+	assert.NoError(t, err)
+	defer UscallClose(fd)
+
+	n, err = UscallEpollWait(int32(epfd), &events[0], 8, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = UscallEpollCtl(int32(epfd), EPOLL_CTL_DEL, sockfd, nil)
+	assert.NoError(t, err)
+	_, err = UscallEpollCtl(int32(epfd), EPOLL_CTL_DEL, sockfd, nil)
+	assert.Equal(t, syscall.ENOENT, err)
+}
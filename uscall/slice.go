@@ -20,6 +20,14 @@ func Bytes2CSlice(data []byte) *CSlice {
 	}
 }
 
+// Bytes2CSliceTo: like Bytes2CSlice, but fill the cs given, it could be reused without allocation.
+func Bytes2CSliceTo(data []byte, cs *CSlice) *CSlice {
+	bh := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	cs.cap, cs.len = C.uint32_t(bh.Cap), C.uint32_t(bh.Len)
+	cs.ptr = (*C.char)(unsafe.Pointer(bh.Data))
+	return cs
+}
+
 func CSlice2Bytes(cs *CSlice) (data []byte) {
 	bh := (*reflect.SliceHeader)(unsafe.Pointer(&data))
 	bh.Cap, bh.Len = int(cs.cap), int(cs.len)
@@ -39,6 +47,10 @@ func AllocCSlice(len, cap uint32) (cs *CSlice) {
 	return
 }
 
+// FreeCSlice: free the memory of cs allocated by AllocCSlice or AllocCSliceOn.
 func FreeCSlice(cs *CSlice) {
+	if freeHuge(cs) {
+		return
+	}
 	C.slice_free_1(cs)
 }
@@ -0,0 +1,313 @@
+//go:build syscall && uring
+// +build syscall,uring
+
+#include <errno.h>
+#include <poll.h>
+#include <string.h>
+#include <unistd.h>
+#include <sys/mman.h>
+#include <sys/socket.h>
+#include <sys/syscall.h>
+#include "uring.h"
+
+#define URING_PTR(base, off) ((void *)((char *)(base) + (off)))
+
+static void *uring_mmap(int fd, size_t size, off_t off){
+    void *ptr = mmap(NULL, size, PROT_READ | PROT_WRITE, MAP_SHARED | MAP_POPULATE, fd, off);
+    return ptr == MAP_FAILED ? NULL : ptr;
+}
+
+int uring_init(uring *r, unsigned entries){
+    struct io_uring_params p;
+    memset(&p, 0, sizeof(p));
+    memset(r, 0, sizeof(*r));
+
+    r->fd = (int)syscall(__NR_io_uring_setup, entries, &p);
+    if (r->fd < 0) {
+        return -1;
+    }
+
+    r->sq_size = p.sq_off.array + p.sq_entries * sizeof(unsigned);
+    r->cq_size = p.cq_off.cqes + p.cq_entries * sizeof(struct io_uring_cqe);
+    if (p.features & IORING_FEAT_SINGLE_MMAP) {
+        if (r->cq_size > r->sq_size) r->sq_size = r->cq_size;
+        r->cq_size = r->sq_size;
+    }
+
+    if ((r->sq_ptr = uring_mmap(r->fd, r->sq_size, IORING_OFF_SQ_RING)) == NULL) {
+        goto fail;
+    }
+
+    if (p.features & IORING_FEAT_SINGLE_MMAP) {
+        r->cq_ptr = r->sq_ptr;
+    } else if ((r->cq_ptr = uring_mmap(r->fd, r->cq_size, IORING_OFF_CQ_RING)) == NULL) {
+        goto fail;
+    }
+
+    r->sqes_size = p.sq_entries * sizeof(struct io_uring_sqe);
+    if ((r->sqes = uring_mmap(r->fd, r->sqes_size, IORING_OFF_SQES)) == NULL) {
+        goto fail;
+    }
+
+    r->sq_head = URING_PTR(r->sq_ptr, p.sq_off.head);
+    r->sq_tail = URING_PTR(r->sq_ptr, p.sq_off.tail);
+    r->sq_mask = URING_PTR(r->sq_ptr, p.sq_off.ring_mask);
+    r->sq_array = URING_PTR(r->sq_ptr, p.sq_off.array);
+    r->sq_flags = URING_PTR(r->sq_ptr, p.sq_off.flags);
+    r->sq_entries = p.sq_entries;
+    r->sqe_tail = *r->sq_tail;
+
+    r->cq_head = URING_PTR(r->cq_ptr, p.cq_off.head);
+    r->cq_tail = URING_PTR(r->cq_ptr, p.cq_off.tail);
+    r->cq_mask = URING_PTR(r->cq_ptr, p.cq_off.ring_mask);
+    r->cqes = URING_PTR(r->cq_ptr, p.cq_off.cqes);
+    r->cq_entries = p.cq_entries;
+    return 0;
+
+fail:
+    {
+        int err = errno;
+        uring_exit(r);
+        errno = err;
+    }
+    return -1;
+}
+
+void uring_exit(uring *r){
+    if (r->sqes != NULL) {
+        munmap(r->sqes, r->sqes_size);
+    }
+    if (r->cq_ptr != NULL && r->cq_ptr != r->sq_ptr) {
+        munmap(r->cq_ptr, r->cq_size);
+    }
+    if (r->sq_ptr != NULL) {
+        munmap(r->sq_ptr, r->sq_size);
+    }
+    if (r->fd >= 0) {
+        close(r->fd);
+    }
+    memset(r, 0, sizeof(*r));
+    r->fd = -1;
+}
+
+// uring_reserve: make sure n sqes could be taken, linked sqes must be taken together.
+static int uring_reserve(uring *r, unsigned n){
+    unsigned head = __atomic_load_n(r->sq_head, __ATOMIC_ACQUIRE);
+    if (r->sqe_tail - head + n > r->sq_entries) {
+        errno = EBUSY;
+        return -1;
+    }
+    return 0;
+}
+
+static struct io_uring_sqe *uring_get_sqe(uring *r){
+    unsigned idx = r->sqe_tail & *r->sq_mask;
+    struct io_uring_sqe *sqe = &r->sqes[idx];
+
+    r->sq_array[idx] = idx;
+    r->sqe_tail++;
+    memset(sqe, 0, sizeof(*sqe));
+    return sqe;
+}
+
+// uring_prep_poll: the poll is cancelled with its operation by the token, see uring_prep_cancel.
+static void uring_prep_poll(uring *r, int fd, unsigned events, uint64_t token){
+    struct io_uring_sqe *sqe = uring_get_sqe(r);
+    sqe->opcode = IORING_OP_POLL_ADD;
+    sqe->fd = fd;
+    sqe->poll32_events = events;
+    sqe->flags = IOSQE_IO_LINK;
+    sqe->user_data = token | URING_TOKEN_POLL;
+}
+
+static struct io_uring_sqe *uring_prep_rw(uring *r, int op, int fd, unsigned events, uint64_t token, int poll){
+    if (uring_reserve(r, poll ? 2 : 1) < 0) {
+        return NULL;
+    }
+    if (poll) {
+        uring_prep_poll(r, fd, events, token);
+    }
+
+    struct io_uring_sqe *sqe = uring_get_sqe(r);
+    sqe->opcode = op;
+    sqe->fd = fd;
+    sqe->user_data = token;
+    return sqe;
+}
+
+int uring_prep_accept(uring *r, int fd, uint64_t token, int poll){
+    struct io_uring_sqe *sqe = uring_prep_rw(r, IORING_OP_ACCEPT, fd, POLLIN, token, poll);
+    if (sqe == NULL) {
+        return -1;
+    }
+    return 0;
+}
+
+int uring_prep_recv(uring *r, int fd, slice *output, uint64_t token, int poll){
+    struct io_uring_sqe *sqe = uring_prep_rw(r, IORING_OP_RECV, fd, POLLIN, token, poll);
+    if (sqe == NULL) {
+        return -1;
+    }
+    sqe->addr = (uint64_t)(uintptr_t)output->ptr;
+    sqe->len = output->len;
+    return 0;
+}
+
+int uring_prep_send(uring *r, int fd, slice *input, uint64_t token, int poll){
+    struct io_uring_sqe *sqe = uring_prep_rw(r, IORING_OP_SEND, fd, POLLOUT, token, poll);
+    if (sqe == NULL) {
+        return -1;
+    }
+    sqe->addr = (uint64_t)(uintptr_t)input->ptr;
+    sqe->len = input->len;
+    sqe->msg_flags = MSG_NOSIGNAL; // report EPIPE instead of raising SIGPIPE
+    return 0;
+}
+
+static int uring_prep_msg(uring *r, int op, int fd, unsigned events, const slice *iov, int n,
+                          uring_msg *msg, unsigned flags, uint64_t token, int poll){
+    struct io_uring_sqe *sqe = uring_prep_rw(r, op, fd, events, token, poll);
+    if (sqe == NULL) {
+        return -1;
+    }
+
+    memset(&msg->hdr, 0, sizeof(msg->hdr));
+    for (int i = 0; i < n; i++) {
+        msg->vec[i].iov_base = iov[i].ptr;
+        msg->vec[i].iov_len = iov[i].len;
+    }
+    msg->hdr.msg_iov = msg->vec;
+    msg->hdr.msg_iovlen = n;
+    sqe->addr = (uint64_t)(uintptr_t)&msg->hdr;
+    sqe->len = 1;
+    sqe->msg_flags = flags;
+    return 0;
+}
+
+int uring_prep_recvmsg(uring *r, int fd, const slice *iov, int n, uring_msg *msg, uint64_t token, int poll){
+    return uring_prep_msg(r, IORING_OP_RECVMSG, fd, POLLIN, iov, n, msg, 0, token, poll);
+}
+
+int uring_prep_sendmsg(uring *r, int fd, const slice *iov, int n, uring_msg *msg, uint64_t token, int poll){
+    return uring_prep_msg(r, IORING_OP_SENDMSG, fd, POLLOUT, iov, n, msg, MSG_NOSIGNAL, token, poll);
+}
+
+int uring_prep_poll_add(uring *r, int fd, unsigned events, uint64_t token){
+    if (uring_reserve(r, 1) < 0) {
+        return -1;
+    }
+
+    struct io_uring_sqe *sqe = uring_get_sqe(r);
+    sqe->opcode = IORING_OP_POLL_ADD;
+    sqe->fd = fd;
+    sqe->poll32_events = events;
+    sqe->user_data = token;
+    return 0;
+}
+
+int uring_prep_close(uring *r, int fd, uint64_t token){
+    if (uring_reserve(r, 2) < 0) {
+        return -1;
+    }
+
+    // the close must run even if there is nothing to cancel, so hard link it.
+    struct io_uring_sqe *sqe = uring_get_sqe(r);
+    sqe->opcode = IORING_OP_ASYNC_CANCEL;
+    sqe->fd = fd;
+    sqe->cancel_flags = IORING_ASYNC_CANCEL_FD | IORING_ASYNC_CANCEL_ALL;
+    sqe->flags = IOSQE_IO_HARDLINK;
+    sqe->user_data = URING_TOKEN_IGNORE;
+
+    sqe = uring_get_sqe(r);
+    sqe->opcode = IORING_OP_CLOSE;
+    sqe->fd = fd;
+    sqe->user_data = token;
+    return 0;
+}
+
+int uring_prep_cancel(uring *r, uint64_t token){
+    if (uring_reserve(r, 2) < 0) {
+        return -1;
+    }
+
+    // the operation waiting the linked poll is not found by its token, cancel the poll too.
+    uint64_t targets[2] = {token, token | URING_TOKEN_POLL};
+    for (int i = 0; i < 2; i++) {
+        struct io_uring_sqe *sqe = uring_get_sqe(r);
+        sqe->opcode = IORING_OP_ASYNC_CANCEL;
+        sqe->fd = -1;
+        sqe->addr = targets[i];
+        sqe->user_data = URING_TOKEN_IGNORE;
+    }
+    return 0;
+}
+
+int uring_submit(uring *r){
+    unsigned head = __atomic_load_n(r->sq_head, __ATOMIC_ACQUIRE);
+    unsigned n = r->sqe_tail - head, flags = 0;
+
+    // flush the overflowed cqes back into the completion ring.
+    if (__atomic_load_n(r->sq_flags, __ATOMIC_RELAXED) & IORING_SQ_CQ_OVERFLOW) {
+        flags |= IORING_ENTER_GETEVENTS;
+    }
+    if (n == 0 && flags == 0) {
+        return 0;
+    }
+
+    __atomic_store_n(r->sq_tail, r->sqe_tail, __ATOMIC_RELEASE);
+    for (;;) {
+        int ret = (int)syscall(__NR_io_uring_enter, r->fd, n, 0, flags, NULL, 0);
+        if (!(ret < 0 && errno == EINTR)) {
+            return ret;
+        }
+    }
+}
+
+int uring_reap(uring *r, uring_cqe *cqes, int max){
+    unsigned head = *r->cq_head;
+    unsigned tail = __atomic_load_n(r->cq_tail, __ATOMIC_ACQUIRE);
+    int n = 0;
+
+    for (; head != tail && n < max; head++) {
+        struct io_uring_cqe *cqe = &r->cqes[head & *r->cq_mask];
+        if (cqe->user_data == URING_TOKEN_IGNORE || cqe->user_data & URING_TOKEN_POLL) {
+            continue;
+        }
+        cqes[n].token = cqe->user_data;
+        cqes[n].res = cqe->res;
+        n++;
+    }
+
+    __atomic_store_n(r->cq_head, head, __ATOMIC_RELEASE);
+    return n;
+}
+
+int uring_wait(uring *r, unsigned timeout_ms){
+    if (*r->cq_head != __atomic_load_n(r->cq_tail, __ATOMIC_ACQUIRE)) {
+        return 0;
+    }
+    if (uring_reserve(r, 1) < 0) {
+        return -1;
+    }
+
+    // the timeout sqe completes after the timeout or any other completion.
+    r->ts.tv_sec = timeout_ms / 1000;
+    r->ts.tv_nsec = (long long)(timeout_ms % 1000) * 1000000;
+    struct io_uring_sqe *sqe = uring_get_sqe(r);
+    sqe->opcode = IORING_OP_TIMEOUT;
+    sqe->addr = (uint64_t)(uintptr_t)&r->ts;
+    sqe->len = 1;
+    sqe->off = 1;
+    sqe->user_data = URING_TOKEN_IGNORE;
+
+    unsigned head = __atomic_load_n(r->sq_head, __ATOMIC_ACQUIRE);
+    unsigned n = r->sqe_tail - head;
+    __atomic_store_n(r->sq_tail, r->sqe_tail, __ATOMIC_RELEASE);
+    for (;;) {
+        int ret = (int)syscall(__NR_io_uring_enter, r->fd, n, 1, IORING_ENTER_GETEVENTS, NULL, 0);
+        if (!(ret < 0 && errno == EINTR)) {
+            return ret;
+        }
+        n = 0; // submitted
+    }
+}
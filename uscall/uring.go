@@ -0,0 +1,155 @@
+//go:build syscall && uring
+// +build syscall,uring
+
+package uscall
+
+/*
+#include <stdlib.h>
+#include "uring.h"
+*/
+import "C"
+import (
+	"syscall"
+	"time"
+)
+
+/*
+Uring is a minimal io_uring instance, it is driven by one thread only:
+prepare the sqes, submit them, then reap the completions by token.
+*/
+type Uring C.struct_uring
+
+type UringCqe C.struct_uring_cqe
+
+// Token: the token passed when the sqe is prepared.
+func (c *UringCqe) Token() uint64 {
+	return uint64(c.token)
+}
+
+// Result: the result of the operation, errno is negative.
+func (c *UringCqe) Result() (int, error) {
+	if res := int(c.res); res < 0 {
+		return 0, syscall.Errno(-res)
+	} else {
+		return res, nil
+	}
+}
+
+func UscallUringSetup(entries uint32) (*Uring, error) {
+	r := new(Uring)
+	if res, err := C.uring_init((*C.struct_uring)(r), C.uint(entries)); res < 0 {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Uring) Close() {
+	C.uring_exit((*C.struct_uring)(r))
+}
+
+func pollFlag(poll bool) C.int {
+	if poll {
+		return 1
+	}
+	return 0
+}
+
+// PrepAccept: if poll is true, the accept is executed after the fd is readable.
+func (r *Uring) PrepAccept(fd int32, token uint64, poll bool) error {
+	if res, err := C.uring_prep_accept((*C.struct_uring)(r), C.int(fd), C.uint64_t(token), pollFlag(poll)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// PrepRecv: the memory of output must be alloced by C and kept until the completion is reaped.
+func (r *Uring) PrepRecv(fd int32, output *CSlice, token uint64, poll bool) error {
+	if res, err := C.uring_prep_recv((*C.struct_uring)(r), C.int(fd), output, C.uint64_t(token), pollFlag(poll)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// PrepSend: the memory of input must be alloced by C and kept until the completion is reaped.
+func (r *Uring) PrepSend(fd int32, input *CSlice, token uint64, poll bool) error {
+	if res, err := C.uring_prep_send((*C.struct_uring)(r), C.int(fd), input, C.uint64_t(token), pollFlag(poll)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// PrepRecvmsg: read into the slices of iov, iov is kept until the completion is reaped.
+func (r *Uring) PrepRecvmsg(fd int32, iov *IOVec, token uint64, poll bool) error {
+	cs, n := iov.cslices()
+	if res, err := C.uring_prep_recvmsg((*C.struct_uring)(r), C.int(fd), cs, n, iov.uringMsg(), C.uint64_t(token), pollFlag(poll)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// PrepSendmsg: write the slices of iov, iov is kept until the completion is reaped.
+func (r *Uring) PrepSendmsg(fd int32, iov *IOVec, token uint64, poll bool) error {
+	cs, n := iov.cslices()
+	if res, err := C.uring_prep_sendmsg((*C.struct_uring)(r), C.int(fd), cs, n, iov.uringMsg(), C.uint64_t(token), pollFlag(poll)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// uringMsg: the msghdr of iov is allocated at the first use, and freed with iov.
+func (v *IOVec) uringMsg() *C.uring_msg {
+	if v.msg == nil {
+		v.msg = C.calloc(1, C.sizeof_uring_msg)
+	}
+	return (*C.uring_msg)(v.msg)
+}
+
+// PrepPoll: wait the epoll events of fd, the result of completion is the mask of happened events.
+func (r *Uring) PrepPoll(fd int32, events uint32, token uint64) error {
+	if res, err := C.uring_prep_poll_add((*C.struct_uring)(r), C.int(fd), C.unsigned(events), C.uint64_t(token)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// PrepClose: cancel all pending operations of fd and close it.
+func (r *Uring) PrepClose(fd int32, token uint64) error {
+	if res, err := C.uring_prep_close((*C.struct_uring)(r), C.int(fd), C.uint64_t(token)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// PrepCancel: cancel the sqe of token, it completes with ECANCELED unless it is done already.
+func (r *Uring) PrepCancel(token uint64) error {
+	if res, err := C.uring_prep_cancel((*C.struct_uring)(r), C.uint64_t(token)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// Submit: submit all prepared sqes to kernel, return the number of submitted sqes.
+func (r *Uring) Submit() (int, error) {
+	res, err := C.uring_submit((*C.struct_uring)(r))
+	if res < 0 {
+		return 0, err
+	}
+	return int(res), nil
+}
+
+// Wait: submit all prepared sqes and block until a completion or the timeout.
+func (r *Uring) Wait(timeout time.Duration) error {
+	ms := (timeout + time.Millisecond - 1) / time.Millisecond
+	if res, err := C.uring_wait((*C.struct_uring)(r), C.uint(ms)); res < 0 {
+		return err
+	}
+	return nil
+}
+
+// Reap: copy the completions into cqes without blocking, return the number of completions.
+func (r *Uring) Reap(cqes []UringCqe) int {
+	if len(cqes) == 0 {
+		return 0
+	}
+	return int(C.uring_reap((*C.struct_uring)(r), (*C.struct_uring_cqe)(&cqes[0]), C.int(len(cqes))))
+}
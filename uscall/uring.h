@@ -0,0 +1,65 @@
+#ifndef __URING_H__
+#define __URING_H__
+
+#include <stdint.h>
+#include <sys/socket.h>
+#include <linux/io_uring.h>
+#include "uscall.h"
+
+// token zero is reserved for the helper sqes (cancel, timeout), their cqes are dropped.
+#define URING_TOKEN_IGNORE 0
+// the linked poll sqe takes the token of its operation with this bit, its cqe is dropped too.
+#define URING_TOKEN_POLL (1ULL << 63)
+
+typedef struct uring_cqe{
+	uint64_t token;
+	int32_t res;
+}uring_cqe;
+
+// the msghdr of recvmsg and sendmsg, it is kept with its iovec array until the completion is reaped.
+typedef struct uring_msg{
+	struct msghdr hdr;
+	struct iovec vec[SLICE_IOV_MAX];
+}uring_msg;
+
+typedef struct uring{
+	int fd;
+
+	unsigned *sq_head, *sq_tail, *sq_mask, *sq_array, *sq_flags;
+	unsigned sq_entries, sqe_tail;
+	struct io_uring_sqe *sqes;
+
+	unsigned *cq_head, *cq_tail, *cq_mask;
+	unsigned cq_entries;
+	struct io_uring_cqe *cqes;
+
+	void *sq_ptr, *cq_ptr;
+	size_t sq_size, cq_size, sqes_size;
+
+	struct __kernel_timespec ts; // the timeout of uring_wait
+}uring;
+
+int uring_init(uring *r, unsigned entries);
+void uring_exit(uring *r);
+
+// prepare the sqes, if poll is not zero, a poll sqe is linked before the operation,
+// which is used to retry the operation after EAGAIN.
+int uring_prep_accept(uring *r, int fd, uint64_t token, int poll);
+int uring_prep_recv(uring *r, int fd, slice *output, uint64_t token, int poll);
+int uring_prep_send(uring *r, int fd, slice *input, uint64_t token, int poll);
+// read into or write from the slices in order, n is SLICE_IOV_MAX at most.
+int uring_prep_recvmsg(uring *r, int fd, const slice *iov, int n, uring_msg *msg, uint64_t token, int poll);
+int uring_prep_sendmsg(uring *r, int fd, const slice *iov, int n, uring_msg *msg, uint64_t token, int poll);
+// wait the events of fd, the result is the mask of the happened events.
+int uring_prep_poll_add(uring *r, int fd, unsigned events, uint64_t token);
+// cancel all pending sqes of fd, then close it.
+int uring_prep_close(uring *r, int fd, uint64_t token);
+// cancel the sqe of token and its linked poll, the cancelled sqe completes with ECANCELED.
+int uring_prep_cancel(uring *r, uint64_t token);
+
+int uring_submit(uring *r);
+int uring_reap(uring *r, uring_cqe *cqes, int max);
+// submit the prepared sqes and wait a completion for timeout_ms at most.
+int uring_wait(uring *r, unsigned timeout_ms);
+
+#endif
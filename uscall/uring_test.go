@@ -0,0 +1,178 @@
+//go:build syscall && uring
+// +build syscall,uring
+
+package uscall
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testReap(t *testing.T, r *Uring, n int) map[uint64]UringCqe {
+	res, cqes := map[uint64]UringCqe{}, make([]UringCqe, n)
+	for len(res) < n {
+		_, err := r.Submit()
+		assert.NoError(t, err)
+		for _, cqe := range cqes[:r.Reap(cqes)] {
+			res[cqe.Token()] = cqe
+		}
+	}
+	return res
+}
+
+func TestUringSendRecv(t *testing.T) {
+	r, err := UscallUringSetup(8)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	defer syscall.Close(fds[1])
+
+	data := []byte("data_xxxx")
+	input, output := AllocCSlice(uint32(len(data)), 64), AllocCSlice(64, 64)
+	defer FreeCSlice(input)
+	defer FreeCSlice(output)
+	copy(CSlice2Bytes(input), data)
+
+	// the fd is nonblocking, recv must wait the linked poll.
+	syscall.SetNonblock(fds[1], true)
+	assert.NoError(t, r.PrepRecv(int32(fds[1]), output, 1, true))
+	assert.NoError(t, r.PrepSend(int32(fds[0]), input, 2, false))
+
+	cqes := testReap(t, r, 2)
+	cqe := cqes[1]
+	n, err := cqe.Result()
+	assert.NoError(t, err)
+	assert.Equal(t, data, CSlice2Bytes(output)[:n])
+
+	// close the peer, then recv returns zero.
+	assert.NoError(t, r.PrepClose(int32(fds[0]), 3))
+	assert.NoError(t, r.PrepRecv(int32(fds[1]), output, 4, true))
+	cqes = testReap(t, r, 2)
+	for _, token := range []uint64{3, 4} {
+		cqe = cqes[token]
+		n, err = cqe.Result()
+		assert.NoError(t, err)
+		assert.Equal(t, 0, n)
+	}
+}
+
+func TestUringSendRecvmsg(t *testing.T) {
+	r, err := UscallUringSetup(8)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+
+	// the Go memory is pinned by iovec, the slices are gathered and scattered in order.
+	input, output := NewIOVec(2), NewIOVec(2)
+	head, body := make([]byte, 4), make([]byte, 16)
+	input.AddBytes([][]byte{[]byte("data"), []byte("_xxxx")})
+	output.AddBytes([][]byte{head, body})
+	defer input.Reset()
+	defer output.Reset()
+
+	syscall.SetNonblock(fds[1], true)
+	assert.NoError(t, r.PrepRecvmsg(int32(fds[1]), output, 1, true))
+	assert.NoError(t, r.PrepSendmsg(int32(fds[0]), input, 2, false))
+
+	cqes := testReap(t, r, 2)
+	cqe := cqes[2]
+	n, err := cqe.Result()
+	assert.NoError(t, err)
+	assert.Equal(t, 9, n)
+	cqe = cqes[1]
+	n, err = cqe.Result()
+	assert.NoError(t, err)
+	assert.Equal(t, 9, n)
+	assert.Equal(t, []byte("data"), head)
+	assert.Equal(t, []byte("_xxxx"), body[:5])
+}
+
+func TestUringAccept(t *testing.T) {
+	r, err := UscallUringSetup(8)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	lfd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	defer syscall.Close(lfd)
+	assert.NoError(t, syscall.Bind(lfd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+	assert.NoError(t, syscall.Listen(lfd, 8))
+	laddr, err := syscall.Getsockname(lfd)
+	assert.NoError(t, err)
+
+	assert.NoError(t, r.PrepAccept(int32(lfd), 1, false))
+	_, err = r.Submit()
+	assert.NoError(t, err)
+
+	cfd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	defer syscall.Close(cfd)
+	assert.NoError(t, syscall.Connect(cfd, laddr))
+
+	cqe := testReap(t, r, 1)[1]
+	fd, err := cqe.Result()
+	assert.NoError(t, err)
+	assert.True(t, fd > 0)
+	syscall.Close(fd)
+}
+
+func TestUringCancelOnClose(t *testing.T) {
+	r, err := UscallUringSetup(8)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	defer syscall.Close(fds[1])
+
+	output := AllocCSlice(64, 64)
+	defer FreeCSlice(output)
+
+	assert.NoError(t, r.PrepRecv(int32(fds[0]), output, 1, false))
+	_, err = r.Submit()
+	assert.NoError(t, err)
+	assert.NoError(t, r.PrepClose(int32(fds[0]), 2))
+
+	cqes := testReap(t, r, 2)
+	cqe := cqes[1]
+	_, err = cqe.Result()
+	assert.Equal(t, syscall.ECANCELED, err)
+	cqe = cqes[2]
+	_, err = cqe.Result()
+	assert.NoError(t, err)
+}
+
+func TestUringCancel(t *testing.T) {
+	r, err := UscallUringSetup(8)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.NoError(t, err)
+	defer syscall.Close(fds[0])
+	defer syscall.Close(fds[1])
+	syscall.SetNonblock(fds[0], true)
+
+	output := AllocCSlice(64, 64)
+	defer FreeCSlice(output)
+
+	// the recv waiting the linked poll and the one waiting alone are both cancelled.
+	for token, poll := range map[uint64]bool{1: true, 2: false} {
+		assert.NoError(t, r.PrepRecv(int32(fds[0]), output, token, poll))
+		_, err = r.Submit()
+		assert.NoError(t, err)
+		assert.NoError(t, r.PrepCancel(token))
+
+		cqe := testReap(t, r, 1)[token]
+		_, err = cqe.Result()
+		assert.Equal(t, syscall.ECANCELED, err)
+	}
+}
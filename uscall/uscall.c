@@ -1,7 +1,16 @@
 #include <stdio.h>
+#include <errno.h>
 #include "uscall.h"
 #include <ff_api.h>
+#include <ff_epoll.h>
 #include <sys/ioctl.h>
+#include <sys/epoll.h>
+#include <sys/socket.h>
+#include <sys/uio.h>
+#include <sys/mman.h>
+#include <linux/mempolicy.h>
+#include <sys/syscall.h>
+#include <unistd.h>
 
 int sys_ioctl_non_bio(int fd, int on){
     return ioctl(fd, FIONBIO,  &on);
@@ -39,6 +48,36 @@ ssize_t sys_write_cslice(int fd, slice*  input){
       return  write(fd, input->ptr, input->len);
 }
 
+// slice_iovec: convert the slices to iovec, return the count converted.
+static int slice_iovec(const slice *iov, int n, struct iovec *vec){
+    if (n > SLICE_IOV_MAX) n = SLICE_IOV_MAX;
+    for (int i = 0; i < n; i++) {
+        vec[i].iov_base = iov[i].ptr;
+        vec[i].iov_len = iov[i].len;
+    }
+    return n;
+}
+
+ssize_t ff_readv_cslice(int fd, const slice *iov, int n){
+    struct iovec vec[SLICE_IOV_MAX];
+    return ff_readv(fd, vec, slice_iovec(iov, n, vec));
+}
+
+ssize_t ff_writev_cslice(int fd, const slice *iov, int n){
+    struct iovec vec[SLICE_IOV_MAX];
+    return ff_writev(fd, vec, slice_iovec(iov, n, vec));
+}
+
+ssize_t sys_readv_cslice(int fd, const slice *iov, int n){
+    struct iovec vec[SLICE_IOV_MAX];
+    return readv(fd, vec, slice_iovec(iov, n, vec));
+}
+
+ssize_t sys_writev_cslice(int fd, const slice *iov, int n){
+    struct iovec vec[SLICE_IOV_MAX];
+    return writev(fd, vec, slice_iovec(iov, n, vec));
+}
+
 slice* slice_alloc( uint32_t cap){
     slice* s = (slice *)malloc(sizeof(slice));
     s->cap, s->len = cap, 0;
@@ -75,6 +114,27 @@ void slice_free_1(slice* s) {
     }
 }
 
+int sys_numa_node(void){
+    unsigned cpu, node;
+    if (syscall(SYS_getcpu, &cpu, &node, NULL) < 0) {
+        return -1;
+    }
+    return node;
+}
+
+void* sys_huge_map(size_t size, int node){
+    void *p = mmap(NULL, size, PROT_READ | PROT_WRITE, MAP_PRIVATE | MAP_ANONYMOUS | MAP_HUGETLB, -1, 0);
+    if (p == MAP_FAILED) {
+        return NULL;
+    }
+    // bind before the first touch, the pages are faulted on node, or any if it is full.
+    if (node >= 0 && node < 64) {
+        unsigned long mask = 1UL << node;
+        syscall(SYS_mbind, p, size, MPOL_PREFERRED, &mask, sizeof(mask) * 8 + 1, 0);
+    }
+    return p;
+}
+
 void slice_clean(slice *s) {
     if (s!=NULL && s->ptr != NULL) {
         memset(s->ptr, 0, s->cap);
@@ -98,4 +158,94 @@ int sys_set_reuse_port(int fd) {
     /* Make sure connection-intensive things like the redis benckmark
      * will be able to close/open sockets a zillion of times */
     return setsockopt(fd, SOL_SOCKET, SO_REUSEADDR, &yes, sizeof(yes));
-}
\ No newline at end of file
+}
+
+int ff_sock_error(int fd) {
+    int err = 0;
+    socklen_t len = sizeof(err);
+    if (ff_getsockopt(fd, SOL_SOCKET, SO_ERROR, &err, &len) < 0) {
+        return -1;
+    }
+    return err;
+}
+
+// the requests of ff_ioctl_freebsd, see sys/filio.h of FreeBSD.
+#define BSD_FIONWRITE 0x40046677
+#define BSD_FIONSPACE 0x40046676
+
+int ff_send_space(int fd, int *queued) {
+    int space = 0;
+    if (ff_ioctl_freebsd(fd, BSD_FIONSPACE, &space) < 0 ||
+        ff_ioctl_freebsd(fd, BSD_FIONWRITE, queued) < 0) {
+        return -1;
+    }
+    return space;
+}
+
+int sys_sock_error(int fd) {
+    int err = 0;
+    socklen_t len = sizeof(err);
+    if (getsockopt(fd, SOL_SOCKET, SO_ERROR, &err, &len) < 0) {
+        return -1;
+    }
+    return err;
+}
+
+// BATCH_EXEC: the result of call, retry on EINTR.
+#define BATCH_EXEC(res, call) do { \
+        ssize_t __ret; \
+        while ((__ret = (call)) < 0 && errno == EINTR); \
+        (res) = __ret < 0 ? -errno : __ret; \
+    } while (0)
+
+int ff_batch(batch_op *ops, int n){
+    for (int i = 0; i < n; i++) {
+        batch_op *op = &ops[i];
+        struct epoll_event ev;
+        switch (op->op) {
+        case BATCH_READ:
+            BATCH_EXEC(op->res, ff_read(op->fd, op->ptr, op->len));
+            break;
+        case BATCH_WRITE:
+            BATCH_EXEC(op->res, ff_write(op->fd, op->ptr, op->len));
+            break;
+        case BATCH_ACCEPT:
+            BATCH_EXEC(op->res, ff_accept(op->fd, NULL, NULL));
+            break;
+        case BATCH_EPOLL_CTL:
+            ev.events = op->events;
+            ev.data.u64 = op->data;
+            BATCH_EXEC(op->res, ff_epoll_ctl(op->arg, op->flags, op->fd, &ev));
+            break;
+        default:
+            op->res = -EINVAL;
+        }
+    }
+    return n;
+}
+
+int sys_batch(batch_op *ops, int n){
+    for (int i = 0; i < n; i++) {
+        batch_op *op = &ops[i];
+        struct epoll_event ev;
+        switch (op->op) {
+        case BATCH_READ:
+            BATCH_EXEC(op->res, read(op->fd, op->ptr, op->len));
+            break;
+        case BATCH_WRITE:
+            BATCH_EXEC(op->res, write(op->fd, op->ptr, op->len));
+            break;
+        case BATCH_ACCEPT:
+            BATCH_EXEC(op->res, accept(op->fd, NULL, NULL));
+            break;
+        case BATCH_EPOLL_CTL:
+            ev.events = op->events;
+            ev.data.u64 = op->data;
+            BATCH_EXEC(op->res, epoll_ctl(op->arg, op->flags, op->fd, &ev));
+            break;
+        default:
+            op->res = -EINVAL;
+        }
+    }
+    return n;
+}
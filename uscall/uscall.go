@@ -1,5 +1,5 @@
-//go:build !syscall
-// +build !syscall
+//go:build !syscall && !netstack
+// +build !syscall,!netstack
 
 package uscall
 
@@ -27,6 +27,14 @@ func UscallEpollWait(epfd int32, events *Epoll_event, maxevents, timeout int32)
 	return int(res), err
 }
 
+// UscallBatch: execute the ops of batch in one cgo call, return the number of ops executed.
+func UscallBatch(b *Batch) int {
+	if b.Len() == 0 {
+		return 0
+	}
+	return int(C.ff_batch(b.cops()))
+}
+
 func UscallRun(loop LoopFunc, arg unsafe.Pointer) {
 	lp := NewLoopParams()
 	lp.BindProc(func() int32 {
@@ -54,6 +62,17 @@ func UscallSetReusePort(fd int32) error {
 	return nil
 }
 
+// UscallSockError: fetch and clear the pending error of socket by SO_ERROR.
+func UscallSockError(fd int32) error {
+	res, err := C.ff_sock_error(C.int(fd))
+	if res < 0 {
+		return err
+	} else if res > 0 {
+		return syscall.Errno(res)
+	}
+	return nil
+}
+
 func UscallIoctlNonBio(fd int32, on int32) (int32, error) {
 	res, err := C.ff_ioctl_non_bio(C.int(fd), C.int(on))
 	return int32(res), err
@@ -69,6 +88,20 @@ func UscallEpollCtl(epfd, op, fd int32, event *Epoll_event) (int, error) {
 	return int(res), err
 }
 
+// UscallEventfd: the kernel eventfd could not be added into ff_epoll, the blocked ff_epoll_wait
+// is not woken, see the idle_sleep of config.ini.
+func UscallEventfd() (int32, error) {
+	return -1, syscall.ENOTSUP
+}
+
+func UscallEventfdWrite(fd int32) error {
+	return syscall.ENOTSUP
+}
+
+func UscallEventfdRead(fd int32) error {
+	return syscall.ENOTSUP
+}
+
 func UscallInit(argv []string) (int, error) {
 	var args [][]byte
 	for _, arg := range argv {
@@ -98,6 +131,18 @@ func UscallAccept(s int32, addr *SockAddr, addrLen *uint32) (int32, error) {
 	return int32(res), err
 }
 
+func UscallGetSockName(s int32, addr *SockAddr, addrLen *uint32) (int, error) {
+	res, err := C.ff_getsockname(C.int(s), (*C.struct_linux_sockaddr)(unsafe.Pointer(addr)),
+		(*C.socklen_t)(unsafe.Pointer(addrLen)))
+	return int(res), err
+}
+
+func UscallGetPeerName(s int32, addr *SockAddr, addrLen *uint32) (int, error) {
+	res, err := C.ff_getpeername(C.int(s), (*C.struct_linux_sockaddr)(unsafe.Pointer(addr)),
+		(*C.socklen_t)(unsafe.Pointer(addrLen)))
+	return int(res), err
+}
+
 func UscallClose(fd int32) (int32, error) {
 	res, err := C.ff_close(C.int(fd))
 	return int32(res), err
@@ -130,3 +175,25 @@ func UscallWriteCSlice(fd int32, input *CSlice) (int, error) {
 		}
 	}
 }
+
+// UscallReadv: read into the slices of iov in order by one call.
+func UscallReadv(fd int32, iov *IOVec) (int, error) {
+	ptr, n := iov.cslices()
+	for {
+		nread, err := C.ff_readv_cslice(C.int(fd), ptr, n)
+		if !(nread < 0 && err == syscall.EINTR) { // ignore EINTR
+			return int(nread), err
+		}
+	}
+}
+
+// UscallWritev: write the slices of iov in order by one call, the written bytes are not consumed.
+func UscallWritev(fd int32, iov *IOVec) (int, error) {
+	ptr, n := iov.cslices()
+	for {
+		nwrite, err := C.ff_writev_cslice(C.int(fd), ptr, n)
+		if !(nwrite <= 0 && err == syscall.EINTR) { // ignore EINTR
+			return int(nwrite), err
+		}
+	}
+}
@@ -9,6 +9,14 @@ int sys_ioctl_non_bio(int fd, int on);
 
 int sys_set_reuse_port(int fd);
 
+// fetch and clear the pending error of socket, return -1 if getsockopt failed.
+int ff_sock_error(int fd);
+int sys_sock_error(int fd);
+
+// the free bytes of the send queue of f-stack socket, the bytes queued are saved in queued,
+// return -1 if ioctl failed.
+int ff_send_space(int fd, int *queued);
+
 typedef struct slice{
 	char* ptr;
 	uint32_t len;
@@ -31,6 +39,44 @@ ssize_t ff_write_cslice(int fd, slice*  input);
 ssize_t sys_read_cslice(int fd, slice* output);
 ssize_t sys_write_cslice(int fd, slice*  input);
 
+// the max count of slices passed to the vectored read and write.
+#define SLICE_IOV_MAX 64
+
+// read into or write from the slices in order by one call, n is SLICE_IOV_MAX at most.
+ssize_t ff_readv_cslice(int fd, const slice *iov, int n);
+ssize_t ff_writev_cslice(int fd, const slice *iov, int n);
+
+ssize_t sys_readv_cslice(int fd, const slice *iov, int n);
+ssize_t sys_writev_cslice(int fd, const slice *iov, int n);
+
 int slice_child(const slice* parent, slice *child,  uint32_t pos,  uint32_t len);
 
+// the NUMA node of the cpu running the calling thread, -1 if it is unknown.
+int sys_numa_node(void);
+// map size bytes of hugepages preferred on node (-1 is any), NULL if no hugepage is available.
+void* sys_huge_map(size_t size, int node);
+
+// the ops of batch
+#define BATCH_READ      1
+#define BATCH_WRITE     2
+#define BATCH_ACCEPT    3
+#define BATCH_EPOLL_CTL 4
+
+// batch_op: an operation executed by the batch, the result is -errno on failure.
+typedef struct batch_op{
+	int32_t op;
+	int32_t fd;
+	int32_t arg;   // the epfd of BATCH_EPOLL_CTL
+	int32_t flags; // the op of BATCH_EPOLL_CTL
+	char *ptr;     // the C memory read into or written from
+	uint32_t len;
+	uint32_t events;
+	uint64_t data;
+	int64_t res;
+}batch_op;
+
+// execute the ops in order, return the number of ops executed.
+int ff_batch(batch_op *ops, int n);
+int sys_batch(batch_op *ops, int n);
+
 #endif
\ No newline at end of file
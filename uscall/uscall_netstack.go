@@ -0,0 +1,742 @@
+//go:build netstack && !syscall
+// +build netstack,!syscall
+
+package uscall
+
+/*
+#cgo CFLAGS:  -I/usr/local/include/
+#cgo LDFLAGS:  -L/usr/local/lib   -Wl,--whole-archive  -ldpdk  -lfstack  -Wl,--no-whole-archive -lrt -lm -ldl -lcrypto -pthread -lnuma
+
+#include <sys/socket.h>
+#include <ff_epoll.h>
+#include <arpa/inet.h>
+*/
+import "C"
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+/*
+the package is used to test with the gVisor netstack, see netstack.go.
+The files(sockets, eventfds and epolls) are kept in a table indexed by fd, the epoll is level-triggered by default.
+*/
+
+// nsFile is the file could be added into epoll, the socket or the eventfd.
+type nsFile interface {
+	queue() *waiter.Queue
+	readiness(mask waiter.EventMask) waiter.EventMask
+	registry() *nsRegistry
+}
+
+// nsRegistry: the items of file registered into epolls.
+type nsRegistry struct {
+	l     sync.Mutex
+	items map[*nsItem]struct{}
+}
+
+type nsSocket struct {
+	nsRegistry
+	ep       tcpip.Endpoint
+	wq       *waiter.Queue
+	nonblock bool
+}
+
+// nsEventfd: readable while the counter is not zero, always writable.
+type nsEventfd struct {
+	nsRegistry
+	wq    waiter.Queue
+	count atomic.Uint64
+}
+
+type nsItem struct {
+	f     nsFile
+	poll  *nsEpoll
+	fd    int32
+	ev    Epoll_event
+	entry waiter.Entry
+}
+
+type nsEpoll struct {
+	l      sync.Mutex
+	items  map[int32]*nsItem
+	ready  map[int32]*nsItem
+	notify chan struct{}
+}
+
+var nsFiles = struct {
+	sync.RWMutex
+	files map[int32]interface{}
+	next  int32
+}{files: map[int32]interface{}{}, next: 3}
+
+func nsAlloc(f interface{}) int32 {
+	nsFiles.Lock()
+	defer nsFiles.Unlock()
+
+	fd := nsFiles.next
+	nsFiles.next++
+	nsFiles.files[fd] = f
+	return fd
+}
+
+func nsSocketOf(fd int32) (*nsSocket, error) {
+	nsFiles.RLock()
+	defer nsFiles.RUnlock()
+
+	if s, ok := nsFiles.files[fd].(*nsSocket); ok {
+		return s, nil
+	}
+	return nil, syscall.EBADF
+}
+
+func nsFileOf(fd int32) (nsFile, error) {
+	nsFiles.RLock()
+	defer nsFiles.RUnlock()
+
+	if f, ok := nsFiles.files[fd].(nsFile); ok {
+		return f, nil
+	}
+	return nil, syscall.EBADF
+}
+
+func nsEventfdOf(fd int32) (*nsEventfd, error) {
+	nsFiles.RLock()
+	defer nsFiles.RUnlock()
+
+	if e, ok := nsFiles.files[fd].(*nsEventfd); ok {
+		return e, nil
+	}
+	return nil, syscall.EBADF
+}
+
+func nsEpollOf(fd int32) (*nsEpoll, error) {
+	nsFiles.RLock()
+	defer nsFiles.RUnlock()
+
+	if p, ok := nsFiles.files[fd].(*nsEpoll); ok {
+		return p, nil
+	}
+	return nil, syscall.EBADF
+}
+
+// nsErrno: translate the netstack error to errno.
+func nsErrno(err tcpip.Error) error {
+	switch err.(type) {
+	case nil:
+		return nil
+	case *tcpip.ErrWouldBlock:
+		return syscall.EAGAIN
+	case *tcpip.ErrConnectionReset:
+		return syscall.ECONNRESET
+	case *tcpip.ErrConnectionAborted, *tcpip.ErrAborted:
+		return syscall.ECONNABORTED
+	case *tcpip.ErrConnectionRefused:
+		return syscall.ECONNREFUSED
+	case *tcpip.ErrClosedForSend:
+		return syscall.EPIPE
+	case *tcpip.ErrNotConnected:
+		return syscall.ENOTCONN
+	case *tcpip.ErrConnectStarted:
+		return syscall.EINPROGRESS
+	case *tcpip.ErrAlreadyConnected:
+		return syscall.EISCONN
+	case *tcpip.ErrPortInUse, *tcpip.ErrDuplicateAddress:
+		return syscall.EADDRINUSE
+	case *tcpip.ErrBadLocalAddress:
+		return syscall.EADDRNOTAVAIL
+	case *tcpip.ErrTimeout:
+		return syscall.ETIMEDOUT
+	case *tcpip.ErrNetworkUnreachable, *tcpip.ErrHostUnreachable:
+		return syscall.ENETUNREACH
+	case *tcpip.ErrMessageTooLong:
+		return syscall.EMSGSIZE
+	case *tcpip.ErrNoBufferSpace:
+		return syscall.ENOBUFS
+	default:
+		return syscall.EINVAL
+	}
+}
+
+// wait: run op until it doesn't block if the socket is blocking.
+func (s *nsSocket) wait(mask waiter.EventMask, op func() tcpip.Error) tcpip.Error {
+	err := op()
+	if _, ok := err.(*tcpip.ErrWouldBlock); !ok || s.nonblock {
+		return err
+	}
+
+	e, ch := waiter.NewChannelEntry(mask | waiter.EventErr | waiter.EventHUp)
+	s.wq.EventRegister(&e)
+	defer s.wq.EventUnregister(&e)
+
+	for {
+		if err = op(); err == nil {
+			return nil
+		} else if _, ok := err.(*tcpip.ErrWouldBlock); !ok {
+			return err
+		}
+		<-ch
+	}
+}
+
+func (s *nsSocket) queue() *waiter.Queue { return s.wq }
+
+func (s *nsSocket) readiness(mask waiter.EventMask) waiter.EventMask { return s.ep.Readiness(mask) }
+
+func (s *nsSocket) close() {
+	s.unregister()
+	s.ep.Close()
+}
+
+func (e *nsEventfd) queue() *waiter.Queue { return &e.wq }
+
+func (e *nsEventfd) readiness(mask waiter.EventMask) waiter.EventMask {
+	ready := waiter.WritableEvents
+	if e.count.Load() > 0 {
+		ready |= waiter.ReadableEvents
+	}
+	return ready & mask
+}
+
+func (r *nsRegistry) registry() *nsRegistry { return r }
+
+// unregister: remove the closed file from epolls.
+func (r *nsRegistry) unregister() {
+	r.l.Lock()
+	items := r.items
+	r.items = nil
+	r.l.Unlock()
+
+	for it := range items {
+		it.poll.remove(it)
+	}
+}
+
+func (p *nsEpoll) setReady(it *nsItem) {
+	p.l.Lock()
+	if p.items[it.fd] == it {
+		p.ready[it.fd] = it
+	}
+	p.l.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (p *nsEpoll) register(it *nsItem) {
+	mask := waiter.EventMaskFromLinux(it.ev.Event()) | waiter.EventErr | waiter.EventHUp
+	it.entry = waiter.NewFunctionEntry(mask, func(waiter.EventMask) {
+		p.setReady(it)
+	})
+	it.f.queue().EventRegister(&it.entry)
+
+	if it.f.readiness(mask) != 0 {
+		p.setReady(it)
+	}
+}
+
+func (p *nsEpoll) remove(it *nsItem) {
+	it.f.queue().EventUnregister(&it.entry)
+
+	p.l.Lock()
+	if p.items[it.fd] == it {
+		delete(p.items, it.fd)
+		delete(p.ready, it.fd)
+	}
+	p.l.Unlock()
+}
+
+// collect: fill the events of ready sockets, level-triggered unless EPOLLET,
+// an edge-triggered socket is reported once until the next notification.
+func (p *nsEpoll) collect(events []Epoll_event) (n int) {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	for fd, it := range p.ready {
+		if n == len(events) {
+			break
+		}
+
+		mask := waiter.EventMaskFromLinux(it.ev.Event()) | waiter.EventErr | waiter.EventHUp
+		if ev := it.f.readiness(mask); ev == 0 {
+			delete(p.ready, fd)
+		} else {
+			events[n] = it.ev
+			events[n].SetEvents(ev.ToLinux())
+			n++
+			if it.ev.Event()&EPOLLET != 0 {
+				delete(p.ready, fd)
+			}
+		}
+	}
+	return
+}
+
+func UscallEpollWait(epfd int32, events *Epoll_event, maxevents, timeout int32) (int, error) {
+	p, err := nsEpollOf(epfd)
+	if err != nil {
+		return -1, err
+	} else if maxevents <= 0 {
+		return -1, syscall.EINVAL
+	}
+
+	var expire <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer t.Stop()
+		expire = t.C
+	}
+
+	output := unsafe.Slice(events, maxevents)
+	for {
+		if n := p.collect(output); n > 0 || timeout == 0 {
+			return n, nil
+		}
+
+		select {
+		case <-p.notify:
+		case <-expire:
+			return 0, nil
+		}
+	}
+}
+
+// UscallBatch: execute the ops of batch in order, there is no cgo call to save on netstack.
+func UscallBatch(b *Batch) int {
+	for i := range b.ops[:b.n] {
+		op := &b.ops[i]
+		cs := CSlice{ptr: op.ptr, len: op.len, cap: op.len}
+
+		var res int
+		var err error
+		switch int32(op.op) {
+		case BATCH_READ:
+			res, err = UscallReadCSlice(int32(op.fd), &cs)
+		case BATCH_WRITE:
+			res, err = UscallWriteCSlice(int32(op.fd), &cs)
+		case BATCH_ACCEPT:
+			var fd int32
+			fd, err = UscallAccept(int32(op.fd), nil, nil)
+			res = int(fd)
+		case BATCH_EPOLL_CTL:
+			ev := (&Epoll_event{}).SetEvents(uint32(op.events)).SetData(uint64(op.data))
+			res, err = UscallEpollCtl(int32(op.arg), int32(op.flags), int32(op.fd), ev)
+		default:
+			err = syscall.EINVAL
+		}
+
+		if errno, ok := err.(syscall.Errno); ok {
+			op.res = -C.int64_t(errno)
+		} else if err != nil {
+			op.res = -C.int64_t(syscall.EIO)
+		} else {
+			op.res = C.int64_t(res)
+		}
+	}
+	return b.n
+}
+
+func UscallRun(loop LoopFunc, arg unsafe.Pointer) {
+	for loop(arg) >= 0 {
+	}
+}
+
+func UscallListen(s int32, backlog int32) (int, error) {
+	sock, err := nsSocketOf(s)
+	if err != nil {
+		return -1, err
+	}
+	if err := nsErrno(sock.ep.Listen(int(backlog))); err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+func UscallBind(s int32, addr *SockAddr, addrLen uint32) (int, error) {
+	sock, err := nsSocketOf(s)
+	if err != nil {
+		return -1, err
+	}
+
+	faddr := tcpip.FullAddress{Port: uint16(C.ntohs(addr.sin_port))}
+	if ip := *(*[4]byte)(unsafe.Pointer(&addr.sin_addr.s_addr)); ip != [4]byte{} {
+		faddr.Addr = tcpip.AddrFrom4(ip)
+	}
+	if err := nsErrno(sock.ep.Bind(faddr)); err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+func UscallSocket(domain, netType, protocol int32) (int32, error) {
+	if domain != AF_INET {
+		return -1, syscall.EAFNOSUPPORT
+	} else if netType != SOCK_STREAM {
+		return -1, syscall.EPROTONOSUPPORT
+	}
+
+	wq := &waiter.Queue{}
+	ep, err := netstackInit().local.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, wq)
+	if err != nil {
+		return -1, nsErrno(err)
+	}
+	return nsAlloc(&nsSocket{ep: ep, wq: wq}), nil
+}
+
+func UscallIoctlNonBio(fd int32, on int32) (int32, error) {
+	sock, err := nsSocketOf(fd)
+	if err != nil {
+		return -1, err
+	}
+	sock.nonblock = on != 0
+	return 0, nil
+}
+
+func UscallSetReusePort(fd int32) error {
+	sock, err := nsSocketOf(fd)
+	if err != nil {
+		return err
+	}
+	sock.ep.SocketOptions().SetReuseAddress(true)
+	return nil
+}
+
+// UscallSockError: fetch and clear the pending error of socket by SO_ERROR.
+func UscallSockError(fd int32) error {
+	sock, err := nsSocketOf(fd)
+	if err != nil {
+		return err
+	}
+	return nsErrno(sock.ep.LastError())
+}
+
+func UscallEpollCreate(size int32) (int, error) {
+	return int(nsAlloc(&nsEpoll{
+		items:  map[int32]*nsItem{},
+		ready:  map[int32]*nsItem{},
+		notify: make(chan struct{}, 1),
+	})), nil
+}
+
+func UscallEpollCtl(epfd, op, fd int32, event *Epoll_event) (int, error) {
+	p, err := nsEpollOf(epfd)
+	if err != nil {
+		return -1, err
+	}
+	f, err := nsFileOf(fd)
+	if err != nil {
+		return -1, err
+	}
+
+	p.l.Lock()
+	it := p.items[fd]
+	switch {
+	case op == EPOLL_CTL_ADD && it != nil:
+		err = syscall.EEXIST
+	case op != EPOLL_CTL_ADD && it == nil:
+		err = syscall.ENOENT
+	case op == EPOLL_CTL_ADD:
+		it = &nsItem{f: f, poll: p, fd: fd, ev: *event}
+		p.items[fd] = it
+	}
+	p.l.Unlock()
+	if err != nil {
+		return -1, err
+	}
+
+	switch op {
+	case EPOLL_CTL_ADD:
+		r := f.registry()
+		r.l.Lock()
+		if r.items == nil {
+			r.items = map[*nsItem]struct{}{}
+		}
+		r.items[it] = struct{}{}
+		r.l.Unlock()
+		p.register(it)
+	case EPOLL_CTL_MOD:
+		f.queue().EventUnregister(&it.entry)
+		p.l.Lock()
+		it.ev = *event
+		delete(p.ready, fd)
+		p.l.Unlock()
+		p.register(it)
+	case EPOLL_CTL_DEL:
+		r := f.registry()
+		r.l.Lock()
+		delete(r.items, it)
+		r.l.Unlock()
+		p.remove(it)
+	default:
+		return -1, syscall.EINVAL
+	}
+	return 0, nil
+}
+
+func UscallEventfd() (int32, error) {
+	return nsAlloc(&nsEventfd{}), nil
+}
+
+func UscallEventfdWrite(fd int32) error {
+	e, err := nsEventfdOf(fd)
+	if err != nil {
+		return err
+	}
+	e.count.Add(1)
+	e.wq.Notify(waiter.ReadableEvents)
+	return nil
+}
+
+func UscallEventfdRead(fd int32) error {
+	e, err := nsEventfdOf(fd)
+	if err != nil {
+		return err
+	} else if e.count.Swap(0) == 0 {
+		return syscall.EAGAIN
+	}
+	return nil
+}
+
+func UscallInit(argv []string) (int, error) {
+	netstackInit()
+	return 0, nil
+}
+
+func UscallAccept(s int32, addr *SockAddr, addrLen *uint32) (int32, error) {
+	sock, err := nsSocketOf(s)
+	if err != nil {
+		return -1, err
+	}
+
+	var peer tcpip.FullAddress
+	var nep tcpip.Endpoint
+	var nwq *waiter.Queue
+	if err := nsErrno(sock.wait(waiter.ReadableEvents, func() (err tcpip.Error) {
+		nep, nwq, err = sock.ep.Accept(&peer)
+		return
+	})); err != nil {
+		return -1, err
+	}
+
+	nsSockAddr(addr, addrLen, peer)
+	return nsAlloc(&nsSocket{ep: nep, wq: nwq}), nil
+}
+
+func nsSockAddr(addr *SockAddr, addrLen *uint32, full tcpip.FullAddress) {
+	if addr != nil {
+		addr.SetFamily(AF_INET).SetPort(uint(full.Port))
+		if full.Addr.Len() == 4 { // the unspecified address is empty
+			*(*[4]byte)(unsafe.Pointer(&addr.sin_addr.s_addr)) = full.Addr.As4()
+		} else {
+			*(*[4]byte)(unsafe.Pointer(&addr.sin_addr.s_addr)) = [4]byte{}
+		}
+		if addrLen != nil {
+			*addrLen = addr.AddrLen()
+		}
+	}
+}
+
+func UscallGetSockName(s int32, addr *SockAddr, addrLen *uint32) (int, error) {
+	sock, err := nsSocketOf(s)
+	if err != nil {
+		return -1, err
+	}
+
+	full, terr := sock.ep.GetLocalAddress()
+	if terr != nil {
+		return -1, nsErrno(terr)
+	}
+	nsSockAddr(addr, addrLen, full)
+	return 0, nil
+}
+
+func UscallGetPeerName(s int32, addr *SockAddr, addrLen *uint32) (int, error) {
+	sock, err := nsSocketOf(s)
+	if err != nil {
+		return -1, err
+	}
+
+	full, terr := sock.ep.GetRemoteAddress()
+	if terr != nil {
+		return -1, nsErrno(terr)
+	}
+	nsSockAddr(addr, addrLen, full)
+	return 0, nil
+}
+
+func UscallClose(fd int32) (int32, error) {
+	nsFiles.Lock()
+	f, ok := nsFiles.files[fd]
+	delete(nsFiles.files, fd)
+	nsFiles.Unlock()
+
+	if !ok {
+		return -1, syscall.EBADF
+	}
+
+	switch f := f.(type) {
+	case *nsSocket:
+		f.close()
+	case *nsEventfd:
+		f.unregister()
+	case *nsEpoll:
+		f.l.Lock()
+		items := f.items
+		f.l.Unlock()
+		for _, it := range items {
+			r := it.f.registry()
+			r.l.Lock()
+			delete(r.items, it)
+			r.l.Unlock()
+			f.remove(it)
+		}
+	}
+	return 0, nil
+}
+
+func UscallRead(fd int32, output []byte) (int, error) {
+	return UscallReadCSlice(fd, Bytes2CSlice(output))
+}
+
+func UscallWrite(fd int32, input []byte) (int, error) {
+	return UscallWriteCSlice(fd, Bytes2CSlice(input))
+}
+
+// UscallReadSlice: Reference Rio
+func UscallReadCSlice(fd int32, output *CSlice) (int, error) {
+	sock, err := nsSocketOf(fd)
+	if err != nil {
+		return -1, err
+	}
+
+	var res tcpip.ReadResult
+	dst := tcpip.SliceWriter(CSlice2Bytes(output))
+	switch err := sock.wait(waiter.ReadableEvents, func() (err tcpip.Error) {
+		res, err = sock.ep.Read(&dst, tcpip.ReadOptions{})
+		return
+	}); err.(type) {
+	case nil:
+		return res.Count, nil
+	case *tcpip.ErrClosedForReceive: // EOF
+		return 0, nil
+	default:
+		return -1, nsErrno(err)
+	}
+}
+
+// UscallReadSlice: Reference Rio
+func UscallWriteCSlice(fd int32, input *CSlice) (int, error) {
+	sock, err := nsSocketOf(fd)
+	if err != nil {
+		return -1, err
+	}
+
+	var nwrite int64
+	src := bytes.NewReader(CSlice2Bytes(input))
+	if err := nsErrno(sock.wait(waiter.WritableEvents, func() (err tcpip.Error) {
+		nwrite, err = sock.ep.Write(src, tcpip.WriteOptions{})
+		return
+	})); err != nil {
+		return -1, err
+	}
+	return int(nwrite), nil
+}
+
+// nsVec: the slices of iovec as the payload read and the buffer written by netstack.
+type nsVec [][]byte
+
+func nsVecOf(iov *IOVec) *nsVec {
+	v := make(nsVec, 0, iov.n)
+	for i := range iov.iov[:iov.n] {
+		v = append(v, CSlice2Bytes(&iov.iov[i]))
+	}
+	return &v
+}
+
+// Read: read the slices in order.
+func (v *nsVec) Read(p []byte) (n int, err error) {
+	for len(p) > 0 && len(*v) > 0 {
+		c := copy(p, (*v)[0])
+		n, p = n+c, p[c:]
+		if (*v)[0] = (*v)[0][c:]; len((*v)[0]) == 0 {
+			*v = (*v)[1:]
+		}
+	}
+	if n == 0 && len(*v) == 0 {
+		err = io.EOF
+	}
+	return
+}
+
+// Write: scatter p into the slices in order, io.ErrShortWrite is returned if they are full.
+func (v *nsVec) Write(p []byte) (n int, err error) {
+	for len(p) > 0 && len(*v) > 0 {
+		c := copy((*v)[0], p)
+		n, p = n+c, p[c:]
+		if (*v)[0] = (*v)[0][c:]; len((*v)[0]) == 0 {
+			*v = (*v)[1:]
+		}
+	}
+	if len(p) > 0 {
+		err = io.ErrShortWrite
+	}
+	return
+}
+
+// Len: the bytes unread, it implements tcpip.Payloader.
+func (v *nsVec) Len() (n int) {
+	for _, b := range *v {
+		n += len(b)
+	}
+	return
+}
+
+func UscallReadv(fd int32, iov *IOVec) (int, error) {
+	sock, err := nsSocketOf(fd)
+	if err != nil {
+		return -1, err
+	}
+
+	var res tcpip.ReadResult
+	dst := nsVecOf(iov)
+	switch err := sock.wait(waiter.ReadableEvents, func() (err tcpip.Error) {
+		res, err = sock.ep.Read(dst, tcpip.ReadOptions{})
+		return
+	}); err.(type) {
+	case nil:
+		return res.Count, nil
+	case *tcpip.ErrClosedForReceive: // EOF
+		return 0, nil
+	default:
+		return -1, nsErrno(err)
+	}
+}
+
+func UscallWritev(fd int32, iov *IOVec) (int, error) {
+	sock, err := nsSocketOf(fd)
+	if err != nil {
+		return -1, err
+	}
+
+	var nwrite int64
+	src := nsVecOf(iov)
+	if err := nsErrno(sock.wait(waiter.WritableEvents, func() (err tcpip.Error) {
+		nwrite, err = sock.ep.Write(src, tcpip.WriteOptions{})
+		return
+	})); err != nil {
+		return -1, err
+	}
+	return int(nwrite), nil
+}
@@ -17,6 +17,7 @@ package uscall
 #include "uscall.h"
 #include <arpa/inet.h>
 #include <unistd.h>
+#include <sys/eventfd.h>
 */
 import "C"
 import (
@@ -44,6 +45,14 @@ func UscallEpollWait(epfd int32, events *Epoll_event, maxevents, timeout int32)
 	}
 }
 
+// UscallBatch: execute the ops of batch in one cgo call, return the number of ops executed.
+func UscallBatch(b *Batch) int {
+	if b.Len() == 0 {
+		return 0
+	}
+	return int(C.sys_batch(b.cops()))
+}
+
 func UscallRun(loop LoopFunc, arg unsafe.Pointer) {
 	lp := NewLoopParams()
 	lp.BindProc(func() int32 {
@@ -77,6 +86,17 @@ func UscallSetReusePort(fd int32) error {
 	return err
 }
 
+// UscallSockError: fetch and clear the pending error of socket by SO_ERROR.
+func UscallSockError(fd int32) error {
+	res, err := C.sys_sock_error(C.int(fd))
+	if res < 0 {
+		return err
+	} else if res > 0 {
+		return syscall.Errno(res)
+	}
+	return nil
+}
+
 func UscallEpollCreate(flag int32) (int, error) {
 	res, err := C.epoll_create1(C.int(flag))
 	return int(res), err
@@ -87,6 +107,28 @@ func UscallEpollCtl(epfd, op, fd int32, event *Epoll_event) (int, error) {
 	return int(res), err
 }
 
+// UscallEventfd: create the nonblocking eventfd, it is added into epoll to wake the wait.
+func UscallEventfd() (int32, error) {
+	res, err := C.eventfd(0, C.EFD_NONBLOCK|C.EFD_CLOEXEC)
+	return int32(res), err
+}
+
+// UscallEventfdWrite: the eventfd is readable until UscallEventfdRead.
+func UscallEventfdWrite(fd int32) error {
+	if res, err := C.eventfd_write(C.int(fd), 1); res < 0 {
+		return err
+	}
+	return nil
+}
+
+func UscallEventfdRead(fd int32) error {
+	var v C.eventfd_t
+	if res, err := C.eventfd_read(C.int(fd), &v); res < 0 {
+		return err
+	}
+	return nil
+}
+
 func UscallInit(argv []string) (int, error) {
 	return 0, nil
 }
@@ -102,6 +144,18 @@ func UscallAccept(s int32, addr *SockAddr, addrLen *uint32) (int32, error) {
 	}
 }
 
+func UscallGetSockName(s int32, addr *SockAddr, addrLen *uint32) (int, error) {
+	res, err := C.getsockname(C.int(s), (*C.struct_sockaddr)(unsafe.Pointer(addr)),
+		(*C.socklen_t)(unsafe.Pointer(addrLen)))
+	return int(res), err
+}
+
+func UscallGetPeerName(s int32, addr *SockAddr, addrLen *uint32) (int, error) {
+	res, err := C.getpeername(C.int(s), (*C.struct_sockaddr)(unsafe.Pointer(addr)),
+		(*C.socklen_t)(unsafe.Pointer(addrLen)))
+	return int(res), err
+}
+
 func UscallClose(fd int32) (int32, error) {
 	res, err := C.close(C.int(fd))
 	return int32(res), err
@@ -134,3 +188,25 @@ func UscallWriteCSlice(fd int32, input *CSlice) (int, error) {
 		}
 	}
 }
+
+// UscallReadv: read into the slices of iov in order by one call.
+func UscallReadv(fd int32, iov *IOVec) (int, error) {
+	ptr, n := iov.cslices()
+	for {
+		nread, err := C.sys_readv_cslice(C.int(fd), ptr, n)
+		if !(nread < 0 && err == syscall.EINTR) { // ignore EINTR
+			return int(nread), err
+		}
+	}
+}
+
+// UscallWritev: write the slices of iov in order by one call, the written bytes are not consumed.
+func UscallWritev(fd int32, iov *IOVec) (int, error) {
+	ptr, n := iov.cslices()
+	for {
+		nwrite, err := C.sys_writev_cslice(C.int(fd), ptr, n)
+		if !(nwrite <= 0 && err == syscall.EINTR) { // ignore EINTR
+			return int(nwrite), err
+		}
+	}
+}
@@ -0,0 +1,98 @@
+//go:build syscall || netstack
+// +build syscall netstack
+
+package uscall
+
+import (
+	"io"
+	"runtime"
+	"syscall"
+)
+
+// ZCGetOnLoop: the C buffer is allocated by any goroutine.
+const ZCGetOnLoop = false
+
+/*
+ZCBuf is the zero-copy send buffer, a C buffer on the kernel and netstack backends.
+
+	The buffer returned by Bytes is filled in place, or by Write, and UscallZCWrite
+	writes it from the C memory straight. It is freed by Free or the finalizer.
+*/
+type ZCBuf struct {
+	cs   *CSlice
+	view *CSlice // the area not written, passed to cgo
+	fill int     // the bytes filled by Write
+	off  int     // the bytes written
+}
+
+// UscallZCGet: get a send buffer of size bytes.
+func UscallZCGet(size int) (*ZCBuf, error) {
+	if size <= 0 {
+		return nil, syscall.EINVAL
+	}
+
+	b := &ZCBuf{cs: AllocCSlice(uint32(size), uint32(size)), view: new(CSlice)}
+	runtime.SetFinalizer(b, (*ZCBuf).Free)
+	return b, nil
+}
+
+// Bytes: the whole buffer to fill in place.
+func (b *ZCBuf) Bytes() []byte {
+	if b.cs == nil {
+		return nil
+	}
+	return CSlice2Bytes(b.cs)
+}
+
+// Write: copy p into the buffer, io.ErrShortWrite is returned if the buffer is full.
+func (b *ZCBuf) Write(p []byte) (int, error) {
+	n := copy(b.Bytes()[b.fill:], p)
+	b.fill += n
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (b *ZCBuf) Len() int {
+	if b.cs == nil {
+		return 0
+	}
+	return int(b.cs.len)
+}
+
+// Remaining: the bytes not written yet.
+func (b *ZCBuf) Remaining() int {
+	return b.Len() - b.off
+}
+
+func (b *ZCBuf) Free() {
+	if b.cs != nil {
+		FreeCSlice(b.cs)
+		b.cs, b.off = nil, 0
+		runtime.SetFinalizer(b, nil)
+	}
+}
+
+// CData: the remaining bytes, the view is reused, it is valid until the next call.
+func (b *ZCBuf) CData() *CSlice {
+	return Bytes2CSliceTo(b.Bytes()[b.off:], b.view)
+}
+
+// Consume: n bytes are written.
+func (b *ZCBuf) Consume(n int) {
+	if n > 0 {
+		b.off += n
+	}
+}
+
+// UscallZCWrite: write the remaining bytes, a short write is continued by the next call.
+func UscallZCWrite(fd int32, b *ZCBuf) (int, error) {
+	if b.cs == nil {
+		return -1, syscall.EINVAL
+	}
+
+	nwrite, err := UscallWriteCSlice(fd, b.CData())
+	b.Consume(nwrite)
+	return nwrite, err
+}
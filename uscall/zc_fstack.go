@@ -0,0 +1,121 @@
+//go:build !syscall && !netstack
+// +build !syscall,!netstack
+
+package uscall
+
+/*
+#include <ff_api.h>
+#include "uscall.h"
+*/
+import "C"
+import (
+	"io"
+	"syscall"
+	"unsafe"
+)
+
+// ZCGetOnLoop: the mbufs are allocated on the f-stack thread only, filling them is plain memory copy.
+const ZCGetOnLoop = true
+
+/*
+ZCBuf is the zero-copy send buffer, a chain of mbufs got by ff_zc_mbuf_get on f-stack.
+
+	The chain is not contiguous, so Bytes returns nil and the data is copied into the
+	mbufs by Write, there is no intermediate buffer. UscallZCWrite hands the bytes filled
+	to ff_write at once (f-stack must be built with FSTACK_ZC_SEND), the stack owns the
+	chain after the call returns anything but EAGAIN and EMSGSIZE. The chain not handed
+	is released by Free, both are called on the f-stack thread.
+*/
+type ZCBuf struct {
+	m    C.struct_ff_zc_mbuf
+	size int
+	fill int  // the bytes filled by Write
+	off  int  // the bytes written
+	sent bool // the chain is handed to the stack
+}
+
+// UscallZCGet: get a send buffer of size bytes.
+func UscallZCGet(size int) (*ZCBuf, error) {
+	if size <= 0 {
+		return nil, syscall.EINVAL
+	}
+
+	b := &ZCBuf{size: size}
+	if res, err := C.ff_zc_mbuf_get(&b.m, C.int(size)); res < 0 {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Bytes: the mbufs are not addressable, fill them by Write.
+func (b *ZCBuf) Bytes() []byte {
+	return nil
+}
+
+// Write: copy p into the mbufs, io.ErrShortWrite is returned if the buffer is full.
+func (b *ZCBuf) Write(p []byte) (int, error) {
+	n := min(len(p), b.size-b.fill)
+	if n > 0 {
+		if res, err := C.ff_zc_mbuf_write(&b.m, (*C.char)(unsafe.Pointer(&p[0])), C.int(n)); res < 0 {
+			return 0, err
+		}
+		b.fill += n
+	}
+	if n < len(p) {
+		return n, io.ErrShortWrite
+	}
+	return n, nil
+}
+
+func (b *ZCBuf) Len() int {
+	return b.size
+}
+
+// Remaining: the bytes filled and not written yet.
+func (b *ZCBuf) Remaining() int {
+	return b.fill - b.off
+}
+
+// Free: release the chain not handed to the stack.
+func (b *ZCBuf) Free() {
+	if b.m.bsd_mbuf != nil && !b.sent {
+		C.ff_mbuf_free(b.m.bsd_mbuf)
+		b.m.bsd_mbuf = nil
+	}
+}
+
+// UscallZCWrite: write the bytes filled at once. The chain is handed to ff_write only when the
+// free space of send queue holds all of it, so the stack takes it whole and no byte is dropped:
+// it is kept by EAGAIN until the space is freed, or by EMSGSIZE if the empty queue is smaller.
+func UscallZCWrite(fd int32, b *ZCBuf) (int, error) {
+	if b.sent || b.m.bsd_mbuf == nil || b.fill == 0 {
+		return -1, syscall.EINVAL
+	}
+
+	var queued C.int
+	if space, err := C.ff_send_space(C.int(fd), &queued); space < 0 {
+		return -1, err
+	} else if int(space) < b.fill && queued == 0 {
+		return -1, syscall.EMSGSIZE
+	} else if int(space) < b.fill {
+		return -1, syscall.EAGAIN
+	}
+
+	for {
+		nwrite, err := C.ff_write(C.int(fd), b.m.bsd_mbuf, C.size_t(b.fill))
+		if nwrite < 0 && err == syscall.EINTR {
+			continue
+		} else if nwrite < 0 && err == syscall.EAGAIN {
+			return -1, err
+		}
+
+		b.m.bsd_mbuf, b.sent = nil, true // owned by the stack
+		if nwrite < 0 {
+			return -1, err
+		}
+		if b.off = int(nwrite); b.off < b.fill { // not expected as the space is checked
+			return int(nwrite), io.ErrShortWrite
+		}
+		return int(nwrite), nil
+	}
+}
@@ -0,0 +1,49 @@
+//go:build syscall
+// +build syscall
+
+package uscall
+
+import (
+	"io"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZCBuf(t *testing.T) {
+	_, err := UscallZCGet(0)
+	assert.Equal(t, syscall.EINVAL, err)
+
+	r, w := testPipe(t)
+	b, err := UscallZCGet(9)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, b.Len())
+
+	// filled in place and by Write.
+	copy(b.Bytes(), "data_")
+	n, err := b.Write([]byte("data_"))
+	assert.Equal(t, 5, n)
+	n, err = b.Write([]byte("xxxx_"))
+	assert.Equal(t, 4, n)
+	assert.Equal(t, io.ErrShortWrite, err)
+
+	// written from the remaining bytes.
+	b.Consume(5)
+	assert.Equal(t, 4, b.Remaining())
+	n, err = UscallZCWrite(w, b)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, 0, b.Remaining())
+
+	output := AllocCSlice(64, 64)
+	defer FreeCSlice(output)
+	n, err = UscallReadCSlice(r, output)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("xxxx"), CSlice2Bytes(output)[:n])
+
+	b.Free()
+	assert.Nil(t, b.Bytes())
+	_, err = UscallZCWrite(w, b)
+	assert.Equal(t, syscall.EINVAL, err)
+}